@@ -4,30 +4,87 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
 	_ "embed"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 //go:embed pricing.json
 var embeddedPricing []byte
 
+// commentMarker identifies the Plarix PR comment. PLARIX_COMMENT_KEY gives
+// each analysis variant (e.g. per profile) its own marker and so its own
+// comment.
+var commentMarker = "<!-- plarix-action -->"
+
+// commentKeyPattern limits PLARIX_COMMENT_KEY to characters that are safe
+// inside an HTML comment.
+var commentKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// version is the release tag, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// exactTokens makes formatInt print full counts (PLARIX_TOKEN_FORMAT=exact)
+// instead of the abbreviated K/M form.
+var exactTokens bool
+
+// materialPercent is the relative cost change, in percent, below which the
+// report calls a delta roughly flat (PLARIX_MATERIAL_PERCENT).
+var materialPercent = 5.0
+
+// maxPricingAgeDays is how old pricing.json may get before reports warn
+// (PLARIX_MAX_PRICING_AGE_DAYS); zero disables the check.
+var maxPricingAgeDays int
+
 const (
 	configPath       = ".plarix.yml"
-	commentMarker    = "<!-- plarix-action -->"
 	defaultUserAgent = "plarix-action"
+	defaultTitle     = "📊 Plarix LLM Cost Analysis"
+
+	// Earlier analyses are kept below historyDivider, one per historyEntry.
+	historyDivider      = "--- history ---"
+	historyEntry        = "<!-- plarix-history-entry -->"
+	defaultHistoryLimit = 5
+
+	// defaultMaxFiles matches the most files the GitHub API lists for a PR.
+	defaultMaxFiles = 3000
+
+	// defaultMinCalls is the smallest measured log reported without a
+	// low-sample warning (PLARIX_MEASURE_MIN_CALLS).
+	defaultMinCalls = 30
+
+	// maxCommentChars is the longest comment body GitHub accepts.
+	maxCommentChars = 65536
 )
 
 // Data source modes
 const (
 	DataSourceMeasured           = "MEASURED"
+	DataSourceMeasuredLowSample  = "MEASURED (LOW SAMPLE)"
 	DataSourceConfiguredEstimate = "CONFIGURED ESTIMATE"
 	DataSourceHeuristicOnly      = "HEURISTIC ONLY"
 )
@@ -35,15 +92,60 @@ const (
 // Config mirrors the small YAML-like assumptions file.
 type Config struct {
 	Assumptions Assumptions
+	Budgets     map[string]float64    // PR label -> cost ceiling in USD
+	Profiles    map[string][]configKV // profile name -> assumption overrides
+	Files       FileFilter            // which PR files are scanned for signals
+	// WatchModels are names or globs (o1, claude-3-opus*) whose introduction
+	// is called out at the top of the report.
+	WatchModels []string
+	// DefaultModels names the model to price when the diff switches to a
+	// provider other than the configured one without naming a model.
+	DefaultModels map[string]string
+	FreeTokens    map[string]int // model (lowercased) -> monthly free tokens
+	// Families maps model names or globs (lowercased) to a family name for
+	// PLARIX_GROUP_BY_FAMILY, overriding derivedFamily.
+	Families map[string]string
+	// RetryKeys and MaxTokensKeys are extra setting names recognized as
+	// retry counts and output limits, on top of the built-in ones.
+	RetryKeys     []string
+	MaxTokensKeys []string
+}
+
+type configKV struct {
+	Key, Val string
 }
 
 // Assumptions drives cost estimation.
 type Assumptions struct {
-	RequestsPerDay  int
+	RequestsPerDay  float64 // may be fractional or written as 2.5e7
 	AvgInputTokens  int
 	AvgOutputTokens int
 	Provider        string
 	Model           string
+	Profile         string // selected profile, empty when none applied
+	// OpenRouterMultiplier scales rates when Provider is "openrouter" to
+	// account for its markup over the upstream vendor. Zero means 1.
+	OpenRouterMultiplier float64
+	// UnitDivisor is the number of requests per business unit (an end-user
+	// action, a seat-day); UnitName labels the unit. Zero disables it.
+	UnitDivisor float64
+	UnitName    string
+	// PerCallFee is a fixed USD charge added to every request, e.g. by an
+	// API gateway, on top of token costs.
+	PerCallFee float64
+	// MonthlyGrowth is the expected month-over-month change in requests per
+	// day, as a fraction (0.05 for 5%); nonzero adds a growth projection.
+	MonthlyGrowth float64
+	// RolloutPercent is the share of traffic (0-100) a flagged change reaches
+	// near term; zero means full rollout. A rollout:N PR label overrides it.
+	RolloutPercent float64
+	// CacheHitRate is the expected share of input tokens served from a prompt
+	// cache, as a fraction; it blends in the model's cached input rate.
+	CacheHitRate float64
+	// ProviderSet and ModelSet record which of the two .plarix.yml names
+	// explicitly; the rest are defaults.
+	ProviderSet bool
+	ModelSet    bool
 }
 
 // PricingFile holds baked-in pricing data.
@@ -51,6 +153,36 @@ type PricingFile struct {
 	LastUpdated string       `json:"last_updated"`
 	Sources     []string     `json:"sources"`
 	Models      []ModelPrice `json:"models"`
+	// FreeTokens is the monthly token allowance per model from .plarix.yml
+	// (committed-use credits), billed at zero before list rates apply.
+	FreeTokens map[string]int `json:"-"`
+	// PerCallFee is per_call_fee from .plarix.yml, added to each measured call.
+	PerCallFee float64 `json:"-"`
+	// providerWarned records models inferProvider already warned about, so a
+	// log with thousands of calls warns once per model. parsePricing sets it;
+	// without it every inference warns.
+	providerWarned map[string]bool
+}
+
+// warnOnce reports whether key has not been warned about yet, marking it.
+func (p PricingFile) warnOnce(key string) bool {
+	if p.providerWarned == nil {
+		return true
+	}
+	if p.providerWarned[key] {
+		return false
+	}
+	p.providerWarned[key] = true
+	return true
+}
+
+// freeTokensFor returns the allowance configured for model, matching either
+// the name as written or the pricing row it resolved to.
+func (p PricingFile) freeTokensFor(model string, price ModelPrice) int {
+	if n, ok := p.FreeTokens[strings.ToLower(model)]; ok {
+		return n
+	}
+	return p.FreeTokens[strings.ToLower(price.Name)]
 }
 
 // ModelPrice is per 1M tokens.
@@ -59,19 +191,143 @@ type ModelPrice struct {
 	Name             string  `json:"name"`
 	InputPerMillion  float64 `json:"input_per_million"`
 	OutputPerMillion float64 `json:"output_per_million"`
+	// CachedInputPerMillion is the rate for input served from a prompt
+	// cache. Zero means no discount is known and cached input bills as input.
+	CachedInputPerMillion float64 `json:"cached_input_per_million,omitempty"`
+	// NamePattern is an optional glob (e.g. "claude-3-5-sonnet-20*") matched
+	// when no entry has the exact name, so dated snapshots share one row.
+	NamePattern string `json:"name_pattern,omitempty"`
+	// Tier, when set, replaces both rates for calls whose input exceeds
+	// its threshold (long-context pricing).
+	Tier *PriceTier `json:"tier,omitempty"`
+	// CharsPerToken is the model family's average characters per token,
+	// used to estimate tokens from raw text. Zero means defaultCharsPerToken.
+	CharsPerToken float64 `json:"chars_per_token,omitempty"`
+	// EnergyWhPer1K is a rough inference energy figure per 1K tokens for
+	// PLARIX_SHOW_ENERGY. Zero means unknown.
+	EnergyWhPer1K float64 `json:"energy_wh_per_1k_tokens,omitempty"`
+	// MaxOutputTokens is the model's documented output limit; a larger
+	// max_tokens in the diff is flagged. Zero means unknown.
+	MaxOutputTokens int `json:"max_output_tokens,omitempty"`
+	// MinChargePerCall is the least a provider bills for one call, in USD;
+	// measured calls whose token cost is lower are billed at it.
+	MinChargePerCall float64 `json:"min_charge_per_call,omitempty"`
+}
+
+// PriceTier holds the higher rates that apply above a context-length threshold.
+type PriceTier struct {
+	AboveInputTokens int     `json:"above_input_tokens"`
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
+// defaultCharsPerToken approximates English text across common tokenizers.
+const defaultCharsPerToken = 4.0
+
+// TextTokens estimates how many tokens text occupies for this model,
+// preferring the model's own CharsPerToken over the global default.
+func (p ModelPrice) TextTokens(text string) int {
+	return p.CharTokens(utf8.RuneCountInString(text))
+}
+
+// CharTokens is TextTokens for a character count, which may be a negative
+// delta; the magnitude is rounded up.
+func (p ModelPrice) CharTokens(chars int) int {
+	ratio := p.CharsPerToken
+	if ratio <= 0 {
+		ratio = defaultCharsPerToken
+	}
+	n := int(math.Ceil(math.Abs(float64(chars)) / ratio))
+	if chars < 0 {
+		return -n
+	}
+	return n
+}
+
+// Cost returns the USD cost of one call, selecting the tier by input size.
+func (p ModelPrice) Cost(inputTokens, outputTokens int) float64 {
+	return p.CachedCost(inputTokens, 0, outputTokens)
+}
+
+// CachedCost is Cost for a call where cachedTokens of the input were served
+// from a prompt cache and bill at CachedInputPerMillion.
+func (p ModelPrice) CachedCost(inputTokens, cachedTokens, outputTokens int) float64 {
+	in, out := p.InputPerMillion, p.OutputPerMillion
+	if p.Tier != nil && inputTokens > p.Tier.AboveInputTokens {
+		in, out = p.Tier.InputPerMillion, p.Tier.OutputPerMillion
+	}
+	cachedTokens = min(max(cachedTokens, 0), inputTokens)
+	if p.CachedInputPerMillion == 0 {
+		cachedTokens = 0
+	}
+	uncached := inputTokens - cachedTokens
+	return (float64(uncached)*in + float64(cachedTokens)*p.CachedInputPerMillion + float64(outputTokens)*out) / 1_000_000
+}
+
+// BilledCost is CachedCost raised to MinChargePerCall, the amount actually
+// billed for one measured call.
+func (p ModelPrice) BilledCost(inputTokens, cachedTokens, outputTokens int) float64 {
+	return max(p.CachedCost(inputTokens, cachedTokens, outputTokens), p.MinChargePerCall)
 }
 
 // DiffSignals captures interesting changes from PR diff.
 type DiffSignals struct {
-	BeforeModels []string
-	AfterModels  []string
-	BeforeMax    []int
-	AfterMax     []int
-	BeforeRetry  []int
-	AfterRetry   []int
+	BeforeModels    []string
+	AfterModels     []string
+	BeforeProviders []string // from provider settings and SDK imports
+	AfterProviders  []string
+	BeforeMax       []int
+	AfterMax        []int
+	BeforeRetry     []int
+	AfterRetry      []int
+	// Context-length settings (context_window, num_ctx, max_input_tokens).
+	BeforeContext []int
+	AfterContext  []int
+	// Worker concurrency settings (maxConcurrency, workers, parallelism).
+	BeforeConcurrency []int
+	AfterConcurrency  []int
+	// API endpoints (base_url, baseURL, api_base) on removed and added lines.
+	BeforeBaseURLs []string
+	AfterBaseURLs  []string
+	// Provider API calls (chat.completions.create, messages.create, ...) on
+	// removed and added lines.
+	CallSitesRemoved int
+	CallSitesAdded   int
+	// Characters of system-prompt string literals on removed and added lines.
+	PromptCharsRemoved int
+	PromptCharsAdded   int
+	// Sites are the added lines that set a model or max_tokens, for inline
+	// review comments.
+	Sites []signalSite
+	// MaxTokenPairs pairs each added max_tokens with the nearest model named
+	// on an added or context line of the same hunk, for the output-limit check.
+	MaxTokenPairs []signalSite
+	// Routing weight maps ({"gpt-4o": 0.2, "gpt-4o-mini": 0.8}) on each side,
+	// read from removed or added lines plus surrounding context.
+	BeforeWeights []modelWeight
+	AfterWeights  []modelWeight
+}
+
+// modelWeight is one entry of a weighted model router. Weights are relative;
+// they are normalized by their sum when priced.
+type modelWeight struct {
+	Model  string
+	Weight float64
 }
 
-// MeasuredUsage represents a single API call from JSONL log.
+// signalSite locates a model or max_tokens signal on the PR head.
+type signalSite struct {
+	File      string
+	Line      int // line number in the new file
+	Model     string
+	MaxTokens int
+}
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)`)
+
+// MeasuredUsage represents a single API call from JSONL log. Only these
+// fields are decoded; anything else on the line (prompts, completions) is
+// dropped by json.Unmarshal and never reaches the report.
 type MeasuredUsage struct {
 	Provider          string `json:"provider"`
 	Model             string `json:"model"`
@@ -79,6 +335,67 @@ type MeasuredUsage struct {
 	OutputTokens      int    `json:"output_tokens"`
 	CachedInputTokens int    `json:"cached_input_tokens,omitempty"`
 	Timestamp         string `json:"timestamp,omitempty"`
+	Label             string `json:"label,omitempty"` // feature or endpoint tag
+	// Per-role input tokens from instrumentation that splits the prompt;
+	// they fill InputTokens when it is absent.
+	SystemTokens    int `json:"system_tokens,omitempty"`
+	UserTokens      int `json:"user_tokens,omitempty"`
+	AssistantTokens int `json:"assistant_tokens,omitempty"`
+	// Usage is the nested object of raw SDK responses, read when the flat
+	// fields are absent.
+	Usage *sdkUsage `json:"usage,omitempty"`
+}
+
+// sdkUsage covers OpenAI (prompt/completion) and Anthropic (input/output)
+// usage objects.
+type sdkUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	InputTokens         int `json:"input_tokens"`
+	OutputTokens        int `json:"output_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// logFieldPattern is what a model, provider or label echoed into the report
+// may look like: a short identifier, not free text.
+var logFieldPattern = regexp.MustCompile(`^[\w.:/@+ -]{0,64}$`)
+
+// redactedField replaces a log string that fails logFieldPattern.
+const redactedField = "[redacted]"
+
+// redactFields blanks string fields that look like free text, e.g. a prompt
+// logged under "label" by mistake, since they are echoed into a public PR
+// comment. It reports whether anything was redacted.
+func (u *MeasuredUsage) redactFields() bool {
+	redacted := false
+	for _, f := range []*string{&u.Provider, &u.Model, &u.Label} {
+		if !logFieldPattern.MatchString(*f) {
+			*f = redactedField
+			redacted = true
+		}
+	}
+	return redacted
+}
+
+// fillFromUsage copies nested usage counts into empty flat fields.
+func (u *MeasuredUsage) fillFromUsage() {
+	if u.InputTokens == 0 {
+		u.InputTokens = u.SystemTokens + u.UserTokens + u.AssistantTokens
+	}
+	if u.Usage == nil {
+		return
+	}
+	if u.InputTokens == 0 {
+		u.InputTokens = max(u.Usage.PromptTokens, u.Usage.InputTokens)
+	}
+	if u.OutputTokens == 0 {
+		u.OutputTokens = max(u.Usage.CompletionTokens, u.Usage.OutputTokens)
+	}
+	if u.CachedInputTokens == 0 {
+		u.CachedInputTokens = u.Usage.PromptTokensDetails.CachedTokens
+	}
 }
 
 // MeasuredSummary aggregates measured usage.
@@ -87,7 +404,54 @@ type MeasuredSummary struct {
 	TotalOutputTokens int
 	TotalCost         float64
 	CallCount         int
-	Models            map[string]int // model -> call count
+	Models            map[string]int     // measuredKey -> call count
+	Providers         map[string]int     // provider -> call count, when logged
+	ModelCosts        map[string]float64 // measuredKey -> total cost
+	Labels            map[string]int     // label -> call count ("unlabeled" when absent)
+	LabelCosts        map[string]float64 // label -> total cost
+	SkippedLines      int                // malformed JSONL lines that were ignored
+	MaxCall           *MeasuredCall      // single most expensive call
+
+	TotalCachedInputTokens int
+	// CacheSavings is what caching saved versus full input rates, and
+	// MaxCacheSavings what it would save if every input token were cached.
+	CacheSavings    float64
+	MaxCacheSavings float64
+
+	FreeTokensUsed int // tokens covered by free_tokens allowances
+
+	// EnergyWh sums the rough energy of calls whose model has a coefficient;
+	// EnergyUnknownCalls counts the rest.
+	EnergyWh           float64
+	EnergyUnknownCalls int
+
+	// CallCostMean and CallCostM2 track the per-call cost distribution
+	// (Welford's algorithm) for the significance check.
+	CallCostMean float64
+	CallCostM2   float64
+
+	// Role tokens sum system_tokens, user_tokens and assistant_tokens over
+	// the RoleCalls calls that logged them.
+	SystemTokens    int
+	UserTokens      int
+	AssistantTokens int
+	RoleCalls       int
+}
+
+// callCostVariance is the sample variance of per-call cost.
+func (m *MeasuredSummary) callCostVariance() float64 {
+	if m.CallCount < 2 {
+		return 0
+	}
+	return m.CallCostM2 / float64(m.CallCount-1)
+}
+
+// MeasuredCall is one priced call from a measured log.
+type MeasuredCall struct {
+	Model        string
+	InputTokens  int
+	OutputTokens int
+	Cost         float64
 }
 
 type ghFile struct {
@@ -96,13 +460,38 @@ type ghFile struct {
 }
 
 type ghComment struct {
-	ID   int64  `json:"id"`
+	ID     int64  `json:"id"`
+	NodeID string `json:"node_id"`
+	Body   string `json:"body"`
+}
+
+type ghReviewComment struct {
+	Path string `json:"path"`
+	Line int    `json:"line"` // 0 once the line is outdated
 	Body string `json:"body"`
 }
 
+type ghLabel struct {
+	Name string `json:"name"`
+}
+
 type ghEvent struct {
 	PullRequest struct {
 		Number int `json:"number"`
+		Base   struct {
+			SHA string `json:"sha"`
+		} `json:"base"`
+		Head struct {
+			SHA  string `json:"sha"`
+			Repo struct {
+				Fork bool `json:"fork"`
+			} `json:"repo"`
+		} `json:"head"`
+		Labels []ghLabel `json:"labels"`
+		Draft  *bool     `json:"draft"`
+		User   struct {
+			Login string `json:"login"`
+		} `json:"user"`
 	} `json:"pull_request"`
 	Issue struct {
 		Number      int `json:"number"`
@@ -110,718 +499,5178 @@ type ghEvent struct {
 			URL string `json:"url"`
 		} `json:"pull_request"`
 	} `json:"issue"`
-	Number int `json:"number"`
+	Number  int    `json:"number"`
+	Action  string `json:"action"`
+	Comment struct {
+		Body string `json:"body"`
+	} `json:"comment"`
 }
 
+// recomputeCommand is the PR comment that reruns the analysis on an
+// issue_comment event.
+const recomputeCommand = "/plarix recompute"
+
+// commentPR holds the pull request fetched for an issue_comment run, whose
+// payload has no pull_request object; readEvent fills it in from here.
+var commentPR []byte
+
 type costPair struct {
 	PerRequest float64
 	Monthly    float64
+	// WithinFreeTier is set when a free_tokens allowance covers the whole
+	// monthly projection.
+	WithinFreeTier bool
+	// PerUnit is the list cost per business unit, PerRequest × UnitDivisor.
+	PerUnit float64
 }
 
 func main() {
 	ctx := context.Background()
 
 	pricing, err := findPricing()
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		os.Exit(runVersion(pricing, err))
+	}
 	if err != nil {
 		fatalf("failed to load pricing: %v", err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "lint-config" {
+		os.Exit(runLintConfig(os.Args[2:], pricing))
+	}
+
 	repo := os.Getenv("GITHUB_REPOSITORY")
 	eventPath := os.Getenv("GITHUB_EVENT_PATH")
 	token := os.Getenv("GITHUB_TOKEN")
+	if githubApp, err = loadAppAuth(); err != nil {
+		fatalf("invalid GitHub App settings: %v", err)
+	}
 
-	// Check for measured mode env vars
-	measureBasePath := os.Getenv("PLARIX_MEASURE_BASE")
-	measureHeadPath := os.Getenv("PLARIX_MEASURE_HEAD")
-
-	if eventPath == "" {
-		fatalf("GITHUB_EVENT_PATH is empty")
+	switch tf := strings.ToLower(strings.TrimSpace(os.Getenv("PLARIX_TOKEN_FORMAT"))); tf {
+	case "", "short":
+	case "exact":
+		exactTokens = true
+	default:
+		fmt.Fprintf(os.Stderr, "warn: ignoring invalid PLARIX_TOKEN_FORMAT=%q (want exact or short)\n", tf)
+	}
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("PLARIX_COST_ROUNDING"))); mode {
+	case "", "half-up":
+	case "half-even":
+		costRounding = mode
+	default:
+		fmt.Fprintf(os.Stderr, "warn: ignoring invalid PLARIX_COST_ROUNDING=%q (want half-up or half-even)\n", mode)
 	}
-	if repo == "" {
-		fatalf("GITHUB_REPOSITORY is empty")
+	if key := strings.TrimSpace(os.Getenv("PLARIX_COMMENT_KEY")); key != "" {
+		if !commentKeyPattern.MatchString(key) {
+			fatalf("invalid PLARIX_COMMENT_KEY=%q (use letters, digits, '.', '_' or '-')", key)
+		}
+		commentMarker = "<!-- plarix-action:" + key + " -->"
 	}
-	if token == "" {
-		fatalf("GITHUB_TOKEN is required to read PR diffs")
+	if raw := strings.TrimSpace(os.Getenv("PLARIX_MATERIAL_PERCENT")); raw != "" {
+		if v, err := strconv.ParseFloat(strings.TrimSuffix(raw, "%"), 64); err == nil && v >= 0 {
+			materialPercent = v
+		} else {
+			fmt.Fprintf(os.Stderr, "warn: ignoring invalid PLARIX_MATERIAL_PERCENT=%q\n", raw)
+		}
 	}
 
-	prNumber, err := readPRNumber(eventPath)
-	if err != nil {
-		fatalf("cannot read PR number: %v", err)
+	maxPricingAgeDays = envInt("PLARIX_MAX_PRICING_AGE_DAYS", 0)
+
+	// Pushes have no PR to diff or comment on; report the commit's cost only.
+	if os.Getenv("GITHUB_EVENT_NAME") == "push" {
+		runPush(ctx, pricing, repo, token)
+		failOnStalePricing(pricing)
+		return
 	}
-	if prNumber == 0 {
-		fmt.Println("plarix: not a pull request context, skipping analysis")
+	if os.Getenv("GITHUB_EVENT_NAME") == "issue_comment" && !isRecomputeComment(eventPath) {
+		fmt.Printf("plarix: not a %q comment on a pull request, skipping analysis\n", recomputeCommand)
 		return
 	}
 
-	client := newGHClient(token)
-	files, err := fetchPRFiles(ctx, client, repo, prNumber)
-	if err != nil {
-		fatalf("failed to fetch PR files: %v", err)
+	// Check for measured mode env vars
+	measureBasePath := os.Getenv("PLARIX_MEASURE_BASE")
+	measureHeadPath := os.Getenv("PLARIX_MEASURE_HEAD")
+
+	// A files fixture or a unified diff file replaces the GitHub API
+	// entirely, for local runs and air-gapped CI.
+	fixturePath := os.Getenv("PLARIX_FILES_FIXTURE")
+	diffPath := os.Getenv("PLARIX_DIFF_FILE")
+	offline := fixturePath != "" || diffPath != ""
+
+	var (
+		client         *http.Client
+		files          []ghFile
+		filesTruncated bool
+		prNumber       int
+	)
+	switch {
+	case diffPath != "":
+		files, err = loadDiffFile(diffPath)
+		if err != nil {
+			fatalf("failed to load diff file: %v", err)
+		}
+	case fixturePath != "":
+		files, err = loadFilesFixture(fixturePath)
+		if err != nil {
+			fatalf("failed to load files fixture: %v", err)
+		}
+	default:
+		if eventPath == "" {
+			fatalf("GITHUB_EVENT_PATH is empty")
+		}
+		if repo == "" {
+			fatalf("GITHUB_REPOSITORY is empty")
+		}
+		if token == "" && githubApp == nil {
+			fatalf("GITHUB_TOKEN or GitHub App settings are required to read PR diffs")
+		}
+
+		prNumber, err = readPRNumber(eventPath)
+		if err != nil {
+			fatalf("cannot read PR number: %v", err)
+		}
+		if prNumber == 0 {
+			fmt.Println("plarix: not a pull request context, skipping analysis")
+			return
+		}
+
+		client = newGHClient(token)
+		if os.Getenv("GITHUB_EVENT_NAME") == "issue_comment" {
+			if commentPR, err = fetchPullRequest(ctx, client, repo, prNumber); err != nil {
+				fatalf("failed to fetch pull request: %v", err)
+			}
+		}
+		files, filesTruncated, err = fetchPRFiles(ctx, client, repo, prNumber, envInt("PLARIX_MAX_FILES", defaultMaxFiles))
+		if err != nil {
+			fatalf("failed to fetch PR files: %v", err)
+		}
 	}
 
-	signals := extractSignals(files)
+	profile := strings.TrimSpace(os.Getenv("PLARIX_PROFILE"))
 	cfg, cfgFound := loadConfig(configPath)
+	if cfgFound {
+		if err := cfg.useProfile(profile); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	useSignalKeys(cfg)
+	signals := extractSignals(files, cfg.Files)
+
+	// When the PR edits .plarix.yml itself, compare each side under its own
+	// assumptions so volume or token-size changes show up in the delta.
+	// PLARIX_BASE_REF (a tag or SHA, e.g. the last release) replaces the
+	// merge target as the Before side.
+	baseRef := strings.TrimSpace(os.Getenv("PLARIX_BASE_REF"))
+	baseRefProblem := "no GitHub token to fetch it with"
+	var baseCfg *Assumptions
+	if client != nil && (baseRef != "" || configChanged(files)) {
+		baseSHA, headSHA := readPRShas(eventPath)
+		if baseRef != "" {
+			baseSHA = baseRef
+		}
+		baseRefProblem = "no PR head commit in the event"
+		if baseSHA != "" && headSHA != "" {
+			base, baseFound, baseErr := fetchConfigAt(ctx, client, repo, baseSHA)
+			head, headFound, headErr := fetchConfigAt(ctx, client, repo, headSHA)
+			switch {
+			case baseErr != nil || headErr != nil:
+				fmt.Fprintf(os.Stderr, "warn: cannot fetch %s versions: %v\n", configPath, errors.Join(baseErr, headErr))
+				baseRefProblem = "fetch failed"
+			case !baseFound:
+				baseRefProblem = "not found at that ref"
+			case !headFound:
+				baseRefProblem = "not found on the PR head"
+			default:
+				// A profile missing on the base side just leaves its defaults.
+				_ = base.useProfile(profile)
+				if err := head.useProfile(profile); err != nil {
+					fatalf("%v", err)
+				}
+				baseCfg = &base.Assumptions
+				cfg, cfgFound = head, true
+			}
+		}
+	}
+	// Without the ref's config, Before falls back to the merge target and
+	// the removed diff lines, and the report must say so.
+	if baseRef != "" && baseCfg == nil {
+		fmt.Fprintf(os.Stderr, "warn: PLARIX_BASE_REF=%s: cannot use %s (%s); Before is the merge target instead\n", baseRef, configPath, baseRefProblem)
+		baseRef = ""
+	}
+
+	pricing.FreeTokens = cfg.FreeTokens
+	pricing.PerCallFee = cfg.Assumptions.PerCallFee
 
 	// Try to load measured data
+	maxSkipRatio := strictSkipRatio(os.Getenv("PLARIX_MEASURE_STRICT"))
 	var baseMeasured, headMeasured *MeasuredSummary
 	if measureBasePath != "" {
-		baseMeasured = loadMeasuredUsage(measureBasePath, pricing)
+		if baseMeasured, err = loadMeasuredUsage(measureBasePath, pricing, maxSkipRatio); err != nil {
+			fatalf("measured base log: %v", err)
+		}
 	}
 	if measureHeadPath != "" {
-		headMeasured = loadMeasuredUsage(measureHeadPath, pricing)
+		if headMeasured, err = loadMeasuredUsage(measureHeadPath, pricing, maxSkipRatio); err != nil {
+			fatalf("measured head log: %v", err)
+		}
+	}
+	// Without an explicit base log, PLARIX_MEASURE_BASE_REF reads the head
+	// log's path as committed at that ref (e.g. the base branch).
+	if measureBaseRef := strings.TrimSpace(os.Getenv("PLARIX_MEASURE_BASE_REF")); measureBaseRef != "" && measureBasePath == "" && measureHeadPath != "" && client != nil && isGlob(measureHeadPath) {
+		fmt.Fprintf(os.Stderr, "warn: PLARIX_MEASURE_BASE_REF needs a single PLARIX_MEASURE_HEAD path, not the pattern %s\n", measureHeadPath)
+	} else if measureBaseRef != "" && measureBasePath == "" && measureHeadPath != "" && client != nil {
+		logPath := repoRelative(measureHeadPath)
+		raw, found, fetchErr := fetchFileAt(ctx, client, repo, logPath, measureBaseRef)
+		switch {
+		case fetchErr != nil:
+			fmt.Fprintf(os.Stderr, "warn: cannot fetch %s at %s: %v\n", logPath, measureBaseRef, fetchErr)
+		case !found:
+			fmt.Fprintf(os.Stderr, "warn: no measured log %s at %s\n", logPath, measureBaseRef)
+		default:
+			if baseMeasured, err = parseMeasuredUsage(bytes.NewReader(raw), logPath+"@"+measureBaseRef, pricing, maxSkipRatio); err != nil {
+				fatalf("measured base log: %v", err)
+			}
+		}
 	}
 
-	report := buildReport(reportInput{
-		ConfigFound:  cfgFound,
-		Config:       cfg.Assumptions,
-		Pricing:      pricing,
-		Signals:      signals,
-		BaseMeasured: baseMeasured,
-		HeadMeasured: headMeasured,
-	})
+	// Labels drive budgets and rollout:N, both of which need a config.
+	var labels []string
+	if cfgFound {
+		labels = readPRLabels(eventPath)
+		if labels == nil && client != nil {
+			if labels, err = fetchPRLabels(ctx, client, repo, prNumber); err != nil {
+				fmt.Fprintf(os.Stderr, "warn: cannot fetch PR labels: %v\n", err)
+			}
+		}
+		if v, ok := rolloutFromLabels(labels); ok {
+			cfg.Assumptions.RolloutPercent = v
+		}
+	}
 
-	if summaryPath := os.Getenv("GITHUB_STEP_SUMMARY"); summaryPath != "" {
-		_ = os.WriteFile(summaryPath, []byte(report), 0o644)
-	} else {
-		fmt.Println(report)
+	in := reportInput{
+		ConfigFound:    cfgFound,
+		Config:         cfg.Assumptions,
+		Pricing:        pricing,
+		Signals:        signals,
+		BaseConfig:     baseCfg,
+		BaseRef:        baseRef,
+		DefaultModels:  cfg.DefaultModels,
+		GroupByFamily:  envBool("PLARIX_GROUP_BY_FAMILY"),
+		Families:       cfg.Families,
+		MinCalls:       envInt("PLARIX_MEASURE_MIN_CALLS", defaultMinCalls),
+		FilesChanged:   len(files),
+		SignalFiles:    countSignalFiles(files, cfg.Files),
+		Truncated:      filesTruncated,
+		BaseMeasured:   baseMeasured,
+		HeadMeasured:   headMeasured,
+		ShowPricing:    envBool("PLARIX_SHOW_PRICING"),
+		ShowEnergy:     envBool("PLARIX_SHOW_ENERGY"),
+		ShowConfig:     envBool("PLARIX_SHOW_CONFIG"),
+		Suggestions:    envBool("PLARIX_SUGGESTIONS"),
+		SignalsSummary: signalsSummary(),
+		Title:          os.Getenv("PLARIX_TITLE"),
+		Footer:         os.Getenv("PLARIX_FOOTER"),
+		WatchedAdded:   watchedAdditions(signals, cfg.WatchModels),
 	}
+	// The budget sees the same input as the report, so it checks the After
+	// figure the comment prints.
+	if len(cfg.Budgets) > 0 {
+		in.Budget = evaluateBudget(in, labels, cfg.Budgets)
+	}
+	if url := strings.TrimSpace(os.Getenv("PLARIX_BUDGET_URL")); url != "" {
+		if budgets, err := fetchOrgBudgets(url); err != nil {
+			fmt.Fprintf(os.Stderr, "warn: cannot load org budget file: %v\n", err)
+		} else {
+			in.OrgBudget = evaluateOrgBudget(in, budgets, repo)
+		}
+	}
+	report := buildReport(in)
 
-	if token != "" {
-		if err := upsertComment(ctx, client, repo, prNumber, report); err != nil {
-			fmt.Fprintf(os.Stderr, "warn: failed to update PR comment: %v\n", err)
+	if path := strings.TrimSpace(os.Getenv("PLARIX_ATTRIBUTION_LOG")); path != "" {
+		if err := appendAttribution(path, in, repo, prNumber, eventPath); err != nil {
+			fmt.Fprintf(os.Stderr, "warn: cannot write attribution log: %v\n", err)
 		}
 	}
-}
 
-func findPricing() (PricingFile, error) {
-	var p PricingFile
-	if err := json.Unmarshal(embeddedPricing, &p); err != nil {
-		return PricingFile{}, fmt.Errorf("failed to parse embedded pricing: %w", err)
+	// Forked PRs are known to get a read-only token, so skip their comment
+	// up front; any other read-only token fails the write itself below.
+	summary := report
+	readOnly := client != nil && isForkPR(eventPath)
+	if readOnly {
+		reason := "pull requests from forks get a read-only token"
+		fmt.Fprintf(os.Stderr, "plarix: not commenting on the PR (%s); report is in the job summary only\n", reason)
+		summary += commentSkippedNote(reason)
+		client = nil
 	}
-	return p, nil
-}
 
-func loadConfig(path string) (Config, bool) {
-	// Default to cheap baseline model - but these are ONLY used if config exists
-	cfg := Config{Assumptions: Assumptions{
-		RequestsPerDay:  10000,
-		AvgInputTokens:  800,
-		AvgOutputTokens: 400,
-		Provider:        "openai",
-		Model:           "gpt-4o-mini",
-	}}
+	if envBool("PLARIX_DEBUG_SUMMARY") {
+		summary += debugDetails(in)
+	}
 
-	f, err := os.Open(path)
-	if err != nil {
-		return cfg, false
+	if summaryPath := os.Getenv("GITHUB_STEP_SUMMARY"); summaryPath != "" {
+		_ = os.WriteFile(summaryPath, []byte(summary), 0o644)
+	} else {
+		fmt.Println(summary)
+	}
+	if reportPath := strings.TrimSpace(os.Getenv("PLARIX_REPORT_FILE")); reportPath != "" {
+		if err := writeArtifact(reportPath, summary); err != nil {
+			fmt.Fprintf(os.Stderr, "warn: cannot write report file: %v\n", err)
+		}
+	}
+	if metricsPath := strings.TrimSpace(os.Getenv("PLARIX_METRICS_FILE")); metricsPath != "" {
+		if err := writeArtifact(metricsPath, promMetrics(in)); err != nil {
+			fmt.Fprintf(os.Stderr, "warn: cannot write metrics file: %v\n", err)
+		}
 	}
-	defer f.Close()
 
-	var current string
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		raw := scanner.Text()
-		line := strings.TrimSpace(raw)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+	if client != nil {
+		opts := commentOptions{
+			HistoryLimit:     envInt("PLARIX_COMMENT_HISTORY", defaultHistoryLimit),
+			Dedupe:           envBool("PLARIX_DEDUPE_COMMENTS"),
+			MinimizeResolved: envBool("PLARIX_MINIMIZE_RESOLVED"),
 		}
-		if strings.HasSuffix(line, ":") {
-			current = strings.TrimSuffix(line, ":")
-			continue
+		switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("PLARIX_COMMENT_MODE"))); mode {
+		case "", "upsert":
+		case "append":
+			opts.Append = true
+			opts.Keep = envInt("PLARIX_COMMENT_KEEP", defaultHistoryLimit)
+		default:
+			fmt.Fprintf(os.Stderr, "warn: ignoring invalid PLARIX_COMMENT_MODE=%q (want upsert or append)\n", mode)
 		}
-		if current != "assumptions" {
-			continue
+		body := report
+		draftMode := strings.ToLower(strings.TrimSpace(os.Getenv("PLARIX_DRAFT_MODE")))
+		switch draftMode {
+		case "", "collapse", "skip", "full":
+		default:
+			fmt.Fprintf(os.Stderr, "warn: ignoring invalid PLARIX_DRAFT_MODE=%q (want collapse, skip or full)\n", draftMode)
+			draftMode = ""
 		}
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
-			continue
+		draft := draftMode != "full" && isDraftPR(ctx, client, repo, prNumber, eventPath)
+		if draft {
+			body = draftBody(body)
 		}
-		key := strings.TrimSpace(parts[0])
-		val := strings.Trim(strings.TrimSpace(parts[1]), "\"'")
-		switch key {
-		case "requests_per_day":
-			if v, err := strconv.Atoi(val); err == nil {
-				cfg.Assumptions.RequestsPerDay = v
-			}
-		case "avg_input_tokens":
-			if v, err := strconv.Atoi(val); err == nil {
-				cfg.Assumptions.AvgInputTokens = v
+		// Without a cost increase, only refresh a comment an earlier
+		// regression left behind, and keep it to one line.
+		if before, after, _, ok := costDelta(in); ok && !materialIncrease(before, after) && envBool("PLARIX_ONLY_ON_INCREASE") {
+			opts.UpdateOnly = true
+			body = fmt.Sprintf("%s\n\n✅ **%s:** no material cost increase (%s → %s).\n", commentMarker, safeValue(in.Title, defaultTitle), formatCost(before), formatCost(after))
+		}
+		if draft && draftMode == "skip" {
+			fmt.Println("plarix: draft PR, skipping the PR comment (PLARIX_DRAFT_MODE=skip)")
+		} else if err := upsertComment(ctx, client, repo, prNumber, body, opts); errors.Is(err, errNoPermission) {
+			fmt.Fprintf(os.Stderr, "plarix: token cannot write PR comments (%v); report is in the job summary only\n", err)
+			appendSummary(commentSkippedNote("the token lacks permission to write PR comments"))
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "warn: failed to update PR comment: %v\n", err)
+		}
+		if envBool("PLARIX_INLINE_COMMENTS") && len(in.Signals.Sites) > 0 && !draft {
+			_, headSHA := readPRShas(eventPath)
+			if err := postInlineComments(ctx, client, repo, prNumber, headSHA, in, envInt("PLARIX_INLINE_COMMENTS_MAX", defaultInlineMax)); err != nil {
+				fmt.Fprintf(os.Stderr, "warn: failed to post inline comments: %v\n", err)
 			}
-		case "avg_output_tokens":
-			if v, err := strconv.Atoi(val); err == nil {
-				cfg.Assumptions.AvgOutputTokens = v
+		}
+		if envBool("PLARIX_REVIEW_GATE") {
+			_, headSHA := readPRShas(eventPath)
+			state, _ := prStatus(in)
+			if err := gateReview(ctx, client, repo, prNumber, headSHA, state == "failure", report); err != nil {
+				fmt.Fprintf(os.Stderr, "warn: failed to update PR review: %v\n", err)
 			}
-		case "provider":
-			cfg.Assumptions.Provider = strings.ToLower(val)
-		case "model":
-			cfg.Assumptions.Model = val
 		}
 	}
-	return cfg, true
-}
 
-func loadMeasuredUsage(path string, pricing PricingFile) *MeasuredSummary {
-	f, err := os.Open(path)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "warn: cannot open measured file %s: %v\n", path, err)
-		return nil
+	// Statuses go on the PR head so they show in the PR's checks list;
+	// GITHUB_SHA is the synthetic merge commit on pull_request events.
+	// The read-only token of a fork PR cannot set statuses either.
+	if envBool("PLARIX_COMMIT_STATUS") && !offline && !readOnly {
+		_, sha := readPRShas(eventPath)
+		sha = safeValue(sha, os.Getenv("GITHUB_SHA"))
+		state, description := prStatus(in)
+		if err := createCommitStatus(ctx, newGHClient(token), repo, sha, state, description); err != nil {
+			fmt.Fprintf(os.Stderr, "warn: failed to set commit status: %v\n", err)
+		}
 	}
-	defer f.Close()
 
-	summary := &MeasuredSummary{Models: make(map[string]int)}
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
+	if budget := in.Budget; budget != nil {
+		breach := max(budget.Actual-budget.Ceiling, 0)
+		if err := writeOutputs(map[string]string{
+			"threshold_breached": strconv.FormatBool(budget.Exceeded()),
+			"breached_metric":    budget.Basis,
+			"breach_amount":      roundDecimal(breach, 2),
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "warn: cannot write step outputs: %v\n", err)
 		}
-		var u MeasuredUsage
-		if err := json.Unmarshal([]byte(line), &u); err != nil {
-			continue // skip malformed lines
-		}
-		summary.TotalInputTokens += u.InputTokens
-		summary.TotalOutputTokens += u.OutputTokens
-		summary.CallCount++
-		summary.Models[u.Model]++
-
-		// Compute cost for this call
-		price, _ := priceFor(pricing, u.Provider, u.Model)
-		callCost := (float64(u.InputTokens)*price.InputPerMillion + float64(u.OutputTokens)*price.OutputPerMillion) / 1_000_000
-		summary.TotalCost += callCost
 	}
 
-	if summary.CallCount == 0 {
-		return nil
+	failOnStalePricing(pricing)
+
+	if len(in.WatchedAdded) > 0 && envBool("PLARIX_FAIL_ON_WATCHED") {
+		fatalf("plarix: PR introduces watched model(s): %s", strings.Join(in.WatchedAdded, ", "))
 	}
-	return summary
-}
 
-func readPRNumber(eventPath string) (int, error) {
-	data, err := os.ReadFile(eventPath)
-	if err != nil {
-		return 0, err
+	if budget := in.Budget; budget != nil && budget.Exceeded() {
+		fatalf("plarix: %s cost %s exceeds budget %s for label %q", budget.Basis, formatCost(budget.Actual), formatCost(budget.Ceiling), budget.Label)
 	}
-	var ev ghEvent
-	if err := json.Unmarshal(data, &ev); err != nil {
-		return 0, err
+	if ob := in.OrgBudget; ob != nil && ob.Exceeded() {
+		fatalf("plarix: %s cost %s exceeds org budget %s for %s", ob.Basis, formatCost(ob.Actual), formatCost(ob.Ceiling), ob.Label)
 	}
+}
 
-	if ev.PullRequest.Number != 0 {
-		return ev.PullRequest.Number, nil
+// runPush reports the configured or measured cost of the pushed commit to
+// the step summary, and optionally as a commit status when
+// PLARIX_COMMIT_STATUS is true.
+func runPush(ctx context.Context, pricing PricingFile, repo, token string) {
+	cfg, cfgFound := loadConfig(configPath)
+	if cfgFound {
+		if err := cfg.useProfile(strings.TrimSpace(os.Getenv("PLARIX_PROFILE"))); err != nil {
+			fatalf("%v", err)
+		}
 	}
-	if ev.Issue.Number != 0 && ev.Issue.PullRequest != nil {
-		return ev.Issue.Number, nil
+	pricing.FreeTokens = cfg.FreeTokens
+	pricing.PerCallFee = cfg.Assumptions.PerCallFee
+
+	var measured *MeasuredSummary
+	if path := os.Getenv("PLARIX_MEASURE_HEAD"); path != "" {
+		var err error
+		if measured, err = loadMeasuredUsage(path, pricing, strictSkipRatio(os.Getenv("PLARIX_MEASURE_STRICT"))); err != nil {
+			fatalf("measured head log: %v", err)
+		}
 	}
-	if ev.Number != 0 {
-		return ev.Number, nil
+
+	sha := os.Getenv("GITHUB_SHA")
+	report, description := buildPushReport(pushInput{
+		SHA:         sha,
+		Ref:         os.Getenv("GITHUB_REF"),
+		ConfigFound: cfgFound,
+		Config:      cfg.Assumptions,
+		Pricing:     pricing,
+		Measured:    measured,
+		Title:       os.Getenv("PLARIX_TITLE"),
+		Footer:      os.Getenv("PLARIX_FOOTER"),
+	})
+
+	if summaryPath := os.Getenv("GITHUB_STEP_SUMMARY"); summaryPath != "" {
+		_ = os.WriteFile(summaryPath, []byte(report), 0o644)
+	} else {
+		fmt.Println(report)
 	}
-	if ref := os.Getenv("GITHUB_REF"); strings.HasPrefix(ref, "refs/pull/") {
-		parts := strings.Split(ref, "/")
-		if len(parts) >= 3 {
-			if n, err := strconv.Atoi(parts[2]); err == nil && n > 0 {
-				return n, nil
-			}
+
+	if envBool("PLARIX_COMMIT_STATUS") && description != "" {
+		if (token == "" && githubApp == nil) || repo == "" || sha == "" {
+			fmt.Fprintf(os.Stderr, "warn: commit status needs GITHUB_TOKEN, GITHUB_REPOSITORY and GITHUB_SHA\n")
+			return
+		}
+		if err := createCommitStatus(ctx, newGHClient(token), repo, sha, "success", description); err != nil {
+			fmt.Fprintf(os.Stderr, "warn: failed to set commit status: %v\n", err)
 		}
 	}
-	return 0, nil
 }
 
-func newGHClient(token string) *http.Client {
-	return &http.Client{Timeout: 15 * time.Second, Transport: &authTransport{token: token}}
+func findPricing() (PricingFile, error) {
+	p, err := parsePricing(embeddedPricing)
+	if err != nil {
+		return PricingFile{}, fmt.Errorf("embedded pricing: %w", err)
+	}
+	if url := strings.TrimSpace(os.Getenv("PLARIX_PRICING_URL")); url != "" {
+		remote, err := fetchRemotePricing(url)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warn: cannot use PLARIX_PRICING_URL, falling back to embedded pricing: %v\n", err)
+			return p, nil
+		}
+		return remote, nil
+	}
+	return p, nil
 }
 
-type authTransport struct {
-	token string
-}
+// pricingCacheTTL is how long a fetched PLARIX_PRICING_URL stays fresh, so
+// several Plarix steps in one job fetch it once.
+const pricingCacheTTL = time.Hour
 
-func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	if t.token != "" {
-		req.Header.Set("Authorization", "Bearer "+t.token)
+// fetchRemotePricing loads a pricing.json-shaped file from url, reusing a
+// copy cached in the runner's temp directory while it is fresh.
+func fetchRemotePricing(url string) (PricingFile, error) {
+	cacheDir := os.Getenv("RUNNER_TEMP")
+	if cacheDir == "" {
+		cacheDir = os.TempDir()
 	}
-	req.Header.Set("Accept", "application/vnd.github+json")
-	if req.Header.Get("User-Agent") == "" {
-		req.Header.Set("User-Agent", defaultUserAgent)
+	cachePath := filepath.Join(cacheDir, fmt.Sprintf("plarix-pricing-%x.json", sha256.Sum256([]byte(url))))
+	if info, err := os.Stat(cachePath); err == nil && time.Since(info.ModTime()) < pricingCacheTTL {
+		if data, err := os.ReadFile(cachePath); err == nil {
+			if p, err := parsePricing(data); err == nil {
+				return p, nil
+			}
+		}
 	}
-	return http.DefaultTransport.RoundTrip(req)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return PricingFile{}, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return PricingFile{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return PricingFile{}, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return PricingFile{}, err
+	}
+	p, err := parsePricing(data)
+	if err != nil {
+		return PricingFile{}, fmt.Errorf("%s: %w", url, err)
+	}
+	if err := os.WriteFile(cachePath, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "warn: cannot cache remote pricing: %v\n", err)
+	}
+	return p, nil
 }
 
-func fetchPRFiles(ctx context.Context, client *http.Client, repo string, prNumber int) ([]ghFile, error) {
-	var all []ghFile
-	for page := 1; page <= 10; page++ {
-		url := fmt.Sprintf("https://api.github.com/repos/%s/pulls/%d/files?per_page=100&page=%d", repo, prNumber, page)
-		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		body, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			return nil, err
+// parsePricing decodes and sanity-checks a pricing file.
+func parsePricing(data []byte) (PricingFile, error) {
+	var p PricingFile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return PricingFile{}, err
+	}
+	if err := checkPricing(p); err != nil {
+		return PricingFile{}, err
+	}
+	p.providerWarned = map[string]bool{}
+	return p, nil
+}
+
+// minPricingModels is the fewest rows a plausible pricing table has.
+const minPricingModels = 5
+
+// checkPricing rejects tables a bad update could leave behind, which would
+// otherwise make every report show $0.00 as if calls were free.
+func checkPricing(p PricingFile) error {
+	if len(p.Models) < minPricingModels {
+		return fmt.Errorf("only %d models, want at least %d", len(p.Models), minPricingModels)
+	}
+	for _, m := range p.Models {
+		if m.InputPerMillion > 0 || m.OutputPerMillion > 0 {
+			return nil
 		}
-		if resp.StatusCode >= 400 {
-			return nil, fmt.Errorf("github api: %s", resp.Status)
+	}
+	return errors.New("no model has a positive rate")
+}
+
+func defaultConfig() Config {
+	// Default to cheap baseline model - but these are ONLY used if config exists
+	return Config{Assumptions: Assumptions{
+		RequestsPerDay:  10000,
+		AvgInputTokens:  800,
+		AvgOutputTokens: 400,
+		Provider:        "openai",
+		Model:           "gpt-4o-mini",
+	}}
+}
+
+func loadConfig(path string) (Config, bool) {
+	cfg := defaultConfig()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, false
+	}
+	defer f.Close()
+
+	for _, issue := range parseConfig(f, &cfg) {
+		fmt.Fprintf(os.Stderr, "warn: %s: %s\n", path, issue)
+	}
+	return cfg, true
+}
+
+// runVersion implements "plarix version": the build version and the pricing
+// that loaded, for bug reports and deployment checks.
+func runVersion(pricing PricingFile, pricingErr error) int {
+	fmt.Printf("plarix %s\n", version)
+	if pricingErr != nil {
+		fmt.Printf("pricing: failed to load: %v\n", pricingErr)
+		return 1
+	}
+	fmt.Printf("pricing: updated %s, %d models\n", safeValue(pricing.LastUpdated, "unknown"), len(pricing.Models))
+	return 0
+}
+
+// runLintConfig implements "plarix lint-config [path]": it reports parse
+// issues and unpriced models in a config file and returns the exit code.
+func runLintConfig(args []string, pricing PricingFile) int {
+	path := configPath
+	if len(args) > 0 {
+		path = args[0]
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "plarix: %v\n", err)
+		return 2
+	}
+	defer f.Close()
+
+	cfg := defaultConfig()
+	issues := parseConfig(f, &cfg)
+	issues = append(issues, unpricedConfigModels(cfg, pricing)...)
+	for _, issue := range issues {
+		fmt.Printf("%s: %s\n", path, issue)
+	}
+	if len(issues) > 0 {
+		return 1
+	}
+	fmt.Printf("%s: ok\n", path)
+	return 0
+}
+
+// unpricedConfigModels lists the models cfg would price, directly or through
+// a profile, default_models or free_tokens, that pricing has no rates for.
+func unpricedConfigModels(cfg Config, pricing PricingFile) []string {
+	var issues []string
+	check := func(where, provider, model string) {
+		if _, found := priceFor(pricing, provider, model); !found {
+			issues = append(issues, fmt.Sprintf("%s: no pricing for %s/%s", where, provider, model))
 		}
-		var files []ghFile
-		if err := json.Unmarshal(body, &files); err != nil {
-			return nil, err
+	}
+	check("assumptions", cfg.Assumptions.Provider, cfg.Assumptions.Model)
+	for _, name := range sortedKeys(cfg.Profiles) {
+		c := cfg
+		if err := c.useProfile(name); err == nil {
+			check(fmt.Sprintf("profile %q", name), c.Assumptions.Provider, c.Assumptions.Model)
 		}
-		all = append(all, files...)
-		if len(files) < 100 {
-			break
+	}
+	for _, provider := range sortedKeys(cfg.DefaultModels) {
+		check("default_models", provider, cfg.DefaultModels[provider])
+	}
+	for _, model := range sortedKeys(cfg.FreeTokens) {
+		if _, found := lookupPrice(pricing, "", model); !found {
+			issues = append(issues, fmt.Sprintf("free_tokens: no pricing for %s", model))
 		}
 	}
-	return all, nil
+	return issues
 }
 
-var (
-	modelPattern     = regexp.MustCompile(`(?i)\b(gpt-[\w.-]+|claude-[\w.-]+)\b`)
-	maxTokensPattern = regexp.MustCompile(`(?i)max[_-]?tokens\s*[:=]\s*([0-9]+)`)
-	retryPattern     = regexp.MustCompile(`(?i)(retries|maxRetries|retry\s*count|retry_limit)\s*[:=]\s*([0-9]+)`)
-)
+// configSections are the top-level keys parseConfig understands.
+var configSections = map[string]bool{
+	"assumptions": true, "budgets": true, "profiles": true, "signals": true,
+	"default_models": true, "free_tokens": true, "watch_models": true,
+	"families": true,
+}
 
-func extractSignals(files []ghFile) DiffSignals {
-	var s DiffSignals
-	for _, f := range files {
-		if f.Patch == "" {
+// parseConfig applies the assumptions found in r on top of cfg and records
+// any named profiles for useProfile. Unknown keys and invalid values are
+// skipped and returned as "line N: ..." issues.
+func parseConfig(r io.Reader, cfg *Config) []string {
+	var (
+		current, profile string
+		issues           []string
+		lineNo           int
+	)
+	report := func(format string, args ...any) {
+		issues = append(issues, fmt.Sprintf("line %d: ", lineNo)+fmt.Sprintf(format, args...))
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNo++
+		raw := trimBOM(scanner.Text())
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		scanner := bufio.NewScanner(strings.NewReader(f.Patch))
-		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") || strings.HasPrefix(line, "@@") {
+		// Top-level flow form: watch_models: [o1, claude-3-opus]
+		if val, ok := strings.CutPrefix(line, "watch_models:"); ok && strings.TrimSpace(val) != "" && raw == strings.TrimLeft(raw, " \t") {
+			cfg.WatchModels, current = parseList(val), ""
+			continue
+		}
+		if strings.HasSuffix(line, ":") {
+			// Indented headers under profiles: name a profile, not a section.
+			if current == "profiles" && raw != strings.TrimLeft(raw, " \t") {
+				profile = strings.Trim(strings.TrimSuffix(line, ":"), "\"'")
 				continue
 			}
-			var targetModels *[]string
-			var targetMax *[]int
-			var targetRetry *[]int
-			if strings.HasPrefix(line, "-") {
-				targetModels = &s.BeforeModels
-				targetMax = &s.BeforeMax
-				targetRetry = &s.BeforeRetry
-			} else if strings.HasPrefix(line, "+") {
-				targetModels = &s.AfterModels
-				targetMax = &s.AfterMax
-				targetRetry = &s.AfterRetry
+			current, profile = strings.TrimSuffix(line, ":"), ""
+			if !configSections[current] {
+				report("unknown section %q", current)
+			}
+			continue
+		}
+		if raw == strings.TrimLeft(raw, " \t") && !strings.HasPrefix(line, "- ") {
+			// A top-level key with a value, e.g. a misplaced assumption.
+			// (YAML allows list items at column 0 under their key.)
+			key, _, _ := strings.Cut(line, ":")
+			report("unknown key %q", strings.TrimSpace(key))
+			current = ""
+			continue
+		}
+		if current == "budgets" {
+			// Labels may contain colons (budget:large), so split on the last one.
+			i := strings.LastIndex(line, ":")
+			if i <= 0 {
+				report("expected label: ceiling")
+				continue
+			}
+			label := strings.Trim(strings.TrimSpace(line[:i]), "\"'")
+			raw := strings.Trim(strings.TrimSpace(line[i+1:]), "\"'$")
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil || v < 0 {
+				report("invalid budget %q for %q", raw, label)
+				continue
+			}
+			if cfg.Budgets == nil {
+				cfg.Budgets = make(map[string]float64)
+			}
+			cfg.Budgets[label] = v
+			continue
+		}
+		if current == "free_tokens" {
+			key, val, ok := strings.Cut(line, ":")
+			if !ok {
+				report("expected model: tokens")
+				continue
+			}
+			val = strings.Trim(strings.TrimSpace(val), "\"'")
+			n, ok := parseTokenCount(val)
+			if !ok {
+				report("invalid free_tokens %q", val)
+				continue
+			}
+			if cfg.FreeTokens == nil {
+				cfg.FreeTokens = make(map[string]int)
+			}
+			cfg.FreeTokens[strings.ToLower(strings.Trim(strings.TrimSpace(key), "\"'"))] = n
+			continue
+		}
+		if current == "default_models" {
+			key, val, ok := strings.Cut(line, ":")
+			if !ok {
+				report("expected provider: model")
+				continue
+			}
+			if cfg.DefaultModels == nil {
+				cfg.DefaultModels = make(map[string]string)
+			}
+			cfg.DefaultModels[canonicalProvider(key)] = strings.Trim(strings.TrimSpace(val), "\"'")
+			continue
+		}
+		if current == "families" {
+			key, val, ok := strings.Cut(line, ":")
+			family := strings.Trim(strings.TrimSpace(val), "\"'")
+			if !ok || family == "" {
+				report("expected model: family")
+				continue
+			}
+			if cfg.Families == nil {
+				cfg.Families = make(map[string]string)
+			}
+			cfg.Families[strings.ToLower(strings.Trim(strings.TrimSpace(key), "\"'"))] = family
+			continue
+		}
+		if current == "watch_models" {
+			if item, ok := strings.CutPrefix(line, "- "); ok {
+				cfg.WatchModels = append(cfg.WatchModels, strings.Trim(strings.TrimSpace(item), "\"'"))
 			} else {
+				report("expected \"- model\" list item")
+			}
+			continue
+		}
+		if current == "signals" {
+			key, val, ok := strings.Cut(line, ":")
+			if !ok {
+				report("expected include, exclude, retry_keys or max_tokens_keys")
 				continue
 			}
-
-			for _, m := range modelPattern.FindAllString(line, -1) {
-				*targetModels = append(*targetModels, m)
+			switch key = strings.TrimSpace(key); key {
+			case "include":
+				cfg.Files.Include = parseList(val)
+			case "exclude":
+				cfg.Files.Exclude = parseList(val)
+			case "retry_keys":
+				cfg.RetryKeys = parseList(val)
+			case "max_tokens_keys":
+				cfg.MaxTokensKeys = parseList(val)
+			default:
+				report("unknown signals key %q", key)
 			}
-			for _, m := range maxTokensPattern.FindAllStringSubmatch(line, -1) {
-				if v, err := strconv.Atoi(m[1]); err == nil {
-					*targetMax = append(*targetMax, v)
-				}
+			continue
+		}
+		if current != "assumptions" && current != "profiles" {
+			// Lines under an unknown section were reported with its header.
+			continue
+		}
+		if current == "profiles" && profile == "" {
+			report("expected a profile name before %q", line)
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			report("expected key: value")
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		val := strings.Trim(strings.TrimSpace(parts[1]), "\"'")
+		if current == "profiles" {
+			// Profiles apply later; validate against a scratch copy now so
+			// problems carry their line number.
+			if err := applyAssumption(&Assumptions{}, key, val); err != nil {
+				report("profile %q: %v", profile, err)
+				continue
 			}
-			for _, m := range retryPattern.FindAllStringSubmatch(line, -1) {
-				if v, err := strconv.Atoi(m[2]); err == nil {
-					*targetRetry = append(*targetRetry, v)
-				}
+			if cfg.Profiles == nil {
+				cfg.Profiles = make(map[string][]configKV)
 			}
+			cfg.Profiles[profile] = append(cfg.Profiles[profile], configKV{Key: key, Val: val})
+			continue
+		}
+		if err := applyAssumption(&cfg.Assumptions, key, val); err != nil {
+			report("%v", err)
 		}
 	}
-	return s
+	return issues
 }
 
-func computeEstimate(a Assumptions, pricing PricingFile, model string) (costPair, bool) {
-	price, found := priceFor(pricing, a.Provider, model)
-	perRequest := (float64(a.AvgInputTokens)*price.InputPerMillion + float64(a.AvgOutputTokens)*price.OutputPerMillion) / 1_000_000
-	monthly := perRequest * float64(a.RequestsPerDay) * 30
-	return costPair{PerRequest: perRequest, Monthly: monthly}, found
+// trimBOM strips the UTF-8 byte order mark some Windows editors write at the
+// start of a file. The line readers already drop the \r of CRLF endings.
+func trimBOM(raw string) string {
+	return strings.TrimPrefix(raw, "\ufeff")
 }
 
-func priceFor(pricing PricingFile, provider, model string) (ModelPrice, bool) {
-	provider = strings.ToLower(provider)
-	for _, m := range pricing.Models {
-		if strings.EqualFold(m.Provider, provider) && strings.EqualFold(m.Name, model) {
-			return m, true
+// parseList splits a comma-separated or flow-style ([a, b]) YAML list.
+func parseList(val string) []string {
+	val = strings.TrimSpace(val)
+	val = strings.TrimSuffix(strings.TrimPrefix(val, "["), "]")
+	var out []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.Trim(strings.TrimSpace(item), "\"'"); item != "" {
+			out = append(out, item)
 		}
 	}
-	return ModelPrice{Provider: provider, Name: model}, false
+	return out
 }
 
-type reportInput struct {
-	ConfigFound  bool
-	Config       Assumptions
-	Pricing      PricingFile
-	Signals      DiffSignals
-	BaseMeasured *MeasuredSummary
-	HeadMeasured *MeasuredSummary
+// parsePercent reads a percentage in (0, 100], with or without "%".
+func parsePercent(val string) (float64, bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(val), "%")), 64)
+	if err != nil || v <= 0 || v > 100 {
+		return 0, false
+	}
+	return v, true
 }
 
-func buildReport(in reportInput) string {
-	var b strings.Builder
-	fmt.Fprintf(&b, "%s\n\n", commentMarker)
-	fmt.Fprintf(&b, "## 📊 Plarix LLM Cost Analysis\n\n")
-
-	// Determine data source mode
-	hasMeasured := in.BaseMeasured != nil || in.HeadMeasured != nil
-	hasConfig := in.ConfigFound
-	hasSignals := len(in.Signals.BeforeModels)+len(in.Signals.AfterModels)+len(in.Signals.BeforeMax)+len(in.Signals.AfterMax)+len(in.Signals.BeforeRetry)+len(in.Signals.AfterRetry) > 0
-
-	// Definitions
-	fmt.Fprintf(&b, "**Definitions:**\n")
-	fmt.Fprintf(&b, "- **Before** = base branch (merge target)\n")
-	fmt.Fprintf(&b, "- **After** = PR head (this PR's code)\n\n")
-
-	// Data source
-	var dataSource string
-	if hasMeasured {
-		dataSource = DataSourceMeasured
-	} else if hasConfig {
-		dataSource = DataSourceConfiguredEstimate
-	} else {
-		dataSource = DataSourceHeuristicOnly
+// rolloutFromLabels reads a rollout:N (or rollout:N%) PR label.
+func rolloutFromLabels(labels []string) (float64, bool) {
+	for _, l := range labels {
+		if val, ok := strings.CutPrefix(strings.ToLower(strings.TrimSpace(l)), "rollout:"); ok {
+			if v, ok := parsePercent(val); ok {
+				return v, true
+			}
+			fmt.Fprintf(os.Stderr, "warn: ignoring label %q (want rollout:N with N in 1-100)\n", l)
+		}
 	}
-	fmt.Fprintf(&b, "**Data source:** `%s`\n\n", dataSource)
-
-	// Pricing info
-	fmt.Fprintf(&b, "_Pricing: %s · Sources: %s_\n\n", safeValue(in.Pricing.LastUpdated, "unknown"), strings.Join(in.Pricing.Sources, ", "))
+	return 0, false
+}
 
-	// MEASURED MODE - the "wow" feature
-	if hasMeasured {
-		buildMeasuredReport(&b, in)
-		return b.String()
+// applyAssumption sets one assumption key, leaving a unchanged when the key
+// is unknown or the value invalid.
+func applyAssumption(a *Assumptions, key, val string) error {
+	switch key {
+	case "requests_per_day":
+		v, err := strconv.ParseFloat(strings.ReplaceAll(val, "_", ""), 64)
+		if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("invalid requests_per_day %q", val)
+		}
+		a.RequestsPerDay = v
+	case "avg_input_tokens", "avg_output_tokens":
+		v, ok := parseTokenCount(val)
+		if !ok {
+			return fmt.Errorf("invalid %s %q", key, val)
+		}
+		if key == "avg_input_tokens" {
+			a.AvgInputTokens = v
+		} else {
+			a.AvgOutputTokens = v
+		}
+	case "provider":
+		a.Provider, a.ProviderSet = canonicalProvider(val), true
+	case "model":
+		a.Model, a.ModelSet = val, true
+		// A model without a provider drops the openai default so priceFor
+		// infers the provider from the model.
+		if !a.ProviderSet {
+			a.Provider = ""
+		}
+	case "openrouter_multiplier", "unit_divisor":
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("invalid %s %q", key, val)
+		}
+		if key == "openrouter_multiplier" {
+			a.OpenRouterMultiplier = v
+		} else {
+			a.UnitDivisor = v
+		}
+	case "unit_name":
+		a.UnitName = val
+	case "per_call_fee":
+		v, err := strconv.ParseFloat(strings.TrimPrefix(val, "$"), 64)
+		if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("invalid per_call_fee %q", val)
+		}
+		a.PerCallFee = v
+	case "monthly_growth_percent":
+		v, err := strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64)
+		if err != nil || v <= -100 || math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("invalid monthly_growth_percent %q", val)
+		}
+		a.MonthlyGrowth = v / 100
+	case "rollout_percent":
+		v, ok := parsePercent(val)
+		if !ok {
+			return fmt.Errorf("invalid rollout_percent %q", val)
+		}
+		a.RolloutPercent = v
+	case "cache_hit_percent":
+		v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(val, "%")), 64)
+		if err != nil || v < 0 || v > 100 {
+			return fmt.Errorf("invalid cache_hit_percent %q", val)
+		}
+		a.CacheHitRate = v / 100
+	default:
+		return fmt.Errorf("unknown key %q", key)
 	}
+	return nil
+}
 
-	// CONFIGURED ESTIMATE MODE
-	if hasConfig {
-		buildConfiguredEstimateReport(&b, in, hasSignals)
-		return b.String()
+// useProfile layers the named profile over the base assumptions. An empty
+// name selects "default", which may be absent; any other unknown name is an
+// error.
+func (c *Config) useProfile(name string) error {
+	if name == "" {
+		name = "default"
+	}
+	overrides, ok := c.Profiles[name]
+	if !ok {
+		if name == "default" {
+			return nil
+		}
+		return fmt.Errorf("profile %q not defined in %s", name, configPath)
 	}
+	for _, kv := range overrides {
+		_ = applyAssumption(&c.Assumptions, kv.Key, kv.Val) // validated by parseConfig
+	}
+	c.Assumptions.Profile = name
+	return nil
+}
 
-	// HEURISTIC ONLY MODE - no config, no measured data
-	buildHeuristicOnlyReport(&b, in, hasSignals)
-	return b.String()
+// strictSkipRatio parses PLARIX_MEASURE_STRICT. A boolean true tolerates no
+// malformed lines, a ratio in (0, 1) tolerates up to that share of lines, and
+// anything else (including unset) disables strict mode with -1.
+func strictSkipRatio(raw string) float64 {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return -1
+	}
+	if v, err := strconv.ParseBool(raw); err == nil {
+		if v {
+			return 0
+		}
+		return -1
+	}
+	if v, err := strconv.ParseFloat(raw, 64); err == nil && v > 0 && v < 1 {
+		return v
+	}
+	fmt.Fprintf(os.Stderr, "warn: ignoring invalid PLARIX_MEASURE_STRICT=%q\n", raw)
+	return -1
 }
 
-func buildMeasuredReport(b *strings.Builder, in reportInput) {
-	fmt.Fprintf(b, "### ✅ Measured Token Usage (from CI test runs)\n\n")
+// loadMeasuredUsage aggregates a JSONL usage log, or every log matched by a
+// glob such as artifacts/**/usage.jsonl. Malformed lines are counted and
+// skipped; when maxSkipRatio is non-negative, exceeding it is an error.
+func loadMeasuredUsage(path string, pricing PricingFile, maxSkipRatio float64) (*MeasuredSummary, error) {
+	paths := []string{path}
+	if isGlob(path) {
+		paths = globFiles(path)
+		if len(paths) == 0 {
+			fmt.Fprintf(os.Stderr, "warn: measured log pattern %s matched no files\n", path)
+			return nil, nil
+		}
+	}
+	var readers []io.Reader
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warn: cannot open measured file %s: %v\n", p, err)
+			continue
+		}
+		defer f.Close()
+		// A log without a trailing newline must not merge with the next one.
+		readers = append(readers, f, strings.NewReader("\n"))
+	}
+	if len(readers) == 0 {
+		return nil, nil
+	}
+	return parseMeasuredUsage(io.MultiReader(readers...), path, pricing, maxSkipRatio)
+}
 
-	if in.BaseMeasured != nil && in.HeadMeasured != nil {
-		// Before/After comparison
-		fmt.Fprintf(b, "| | Calls | Input Tokens | Output Tokens | Total Cost |\n")
-		fmt.Fprintf(b, "|---|---:|---:|---:|---:|\n")
-		fmt.Fprintf(b, "| Before | %d | %s | %s | $%.4f |\n",
-			in.BaseMeasured.CallCount,
-			formatInt(in.BaseMeasured.TotalInputTokens),
-			formatInt(in.BaseMeasured.TotalOutputTokens),
-			in.BaseMeasured.TotalCost)
-		fmt.Fprintf(b, "| After | %d | %s | %s | $%.4f |\n\n",
-			in.HeadMeasured.CallCount,
-			formatInt(in.HeadMeasured.TotalInputTokens),
-			formatInt(in.HeadMeasured.TotalOutputTokens),
-			in.HeadMeasured.TotalCost)
+// isGlob reports whether p contains glob metacharacters.
+func isGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
 
-		// Delta
-		delta := in.HeadMeasured.TotalCost - in.BaseMeasured.TotalCost
-		deltaPercent := float64(0)
-		if in.BaseMeasured.TotalCost > 0 {
-			deltaPercent = (delta / in.BaseMeasured.TotalCost) * 100
+// globFiles returns the regular files matching pattern, sorted. Besides the
+// path.Match syntax within a segment, a "**" segment matches any number of
+// directories, including none.
+func globFiles(pattern string) []string {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	segments := strings.Split(pattern, "/")
+	// Walk from the longest prefix without metacharacters.
+	root := "."
+	for i, seg := range segments {
+		if isGlob(seg) {
+			if i > 0 {
+				root = strings.Join(segments[:i], "/")
+				if root == "" {
+					root = "/"
+				}
+			}
+			break
+		}
+	}
+	var matches []string
+	_ = filepath.WalkDir(filepath.FromSlash(root), func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
-		sign := "+"
-		if delta < 0 {
-			sign = ""
+		if d.Type().IsRegular() && matchSegments(segments, strings.Split(filepath.ToSlash(p), "/")) {
+			matches = append(matches, p)
 		}
-		fmt.Fprintf(b, "**Delta:** %s$%.4f (%s%.1f%%)\n\n", sign, delta, sign, deltaPercent)
+		return nil
+	})
+	sort.Strings(matches)
+	return matches
+}
 
-		// Trend bar
-		maxCost := in.BaseMeasured.TotalCost
-		if in.HeadMeasured.TotalCost > maxCost {
-			maxCost = in.HeadMeasured.TotalCost
+// matchSegments matches path segments against pattern segments, where "**"
+// consumes zero or more path segments.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
 		}
-		if maxCost == 0 {
-			maxCost = 1
+		if len(name) == 0 {
+			return false
 		}
-		fmt.Fprintf(b, "```\n")
-		fmt.Fprintf(b, "Before |%s $%.4f\n", bar(in.BaseMeasured.TotalCost, maxCost), in.BaseMeasured.TotalCost)
-		fmt.Fprintf(b, "After  |%s $%.4f\n", bar(in.HeadMeasured.TotalCost, maxCost), in.HeadMeasured.TotalCost)
-		fmt.Fprintf(b, "```\n\n")
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// maxMeasuredLine caps one JSONL line held in memory. Logs that inline big
+// prompts can have multi-megabyte lines, far past bufio.Scanner's 64KB.
+var maxMeasuredLine = 64 << 20
+
+// lineReader reads lines of any length like bufio.Scanner, but skips and
+// counts lines longer than limit instead of stopping, so memory stays bounded
+// by limit and the rest of the input is still read.
+type lineReader struct {
+	r         *bufio.Reader
+	limit     int
+	line      []byte
+	err       error
+	Oversized int
+}
+
+func newLineReader(r io.Reader, limit int) *lineReader {
+	return &lineReader{r: bufio.NewReaderSize(r, 64*1024), limit: limit}
+}
+
+// Scan advances to the next line within the limit, reporting false at the
+// end of input or on a read error.
+func (l *lineReader) Scan() bool {
+	l.line = l.line[:0]
+	tooLong := false
+	for {
+		chunk, isPrefix, err := l.r.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				l.err = err
+			}
+			return false
+		}
+		if !tooLong && len(l.line)+len(chunk) > l.limit {
+			tooLong, l.line = true, l.line[:0]
+		}
+		if !tooLong {
+			l.line = append(l.line, chunk...)
+		}
+		if isPrefix {
+			continue
+		}
+		if !tooLong {
+			return true
+		}
+		l.Oversized++
+		tooLong = false
+	}
+}
+
+func (l *lineReader) Text() string { return string(l.line) }
+
+func (l *lineReader) Err() error { return l.err }
+
+// parseMeasuredUsage summarizes a JSONL log; path labels errors and warnings.
+func parseMeasuredUsage(r io.Reader, path string, pricing PricingFile, maxSkipRatio float64) (*MeasuredSummary, error) {
+	summary := &MeasuredSummary{
+		Models:     make(map[string]int),
+		ModelCosts: make(map[string]float64),
+		Providers:  make(map[string]int),
+		Labels:     make(map[string]int),
+		LabelCosts: make(map[string]float64),
+	}
+	freeLeft := make(map[string]int) // model -> allowance not yet consumed
+	redacted := 0
+	scanner := newLineReader(r, maxMeasuredLine)
+	for scanner.Scan() {
+		line := strings.TrimSpace(trimBOM(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		var u MeasuredUsage
+		if err := json.Unmarshal([]byte(line), &u); err != nil {
+			summary.SkippedLines++
+			continue
+		}
+		u.fillFromUsage()
+		if u.redactFields() {
+			redacted++
+		}
+		u.Provider = canonicalProvider(u.Provider)
+		summary.TotalInputTokens += u.InputTokens
+		summary.TotalOutputTokens += u.OutputTokens
+		summary.CallCount++
+		key := measuredKey(u.Provider, u.Model)
+		summary.Models[key]++
+		if u.Provider != "" {
+			summary.Providers[u.Provider]++
+		}
+
+		// Compute cost for this call
+		var price ModelPrice
+		if u.Provider == "" {
+			// Raw SDK responses carry no provider; find the model anywhere
+			// without priceFor's inference warning.
+			price, _ = lookupPrice(pricing, "", u.Model)
+		} else {
+			price, _ = priceFor(pricing, u.Provider, u.Model)
+		}
+		callCost := price.BilledCost(u.InputTokens, u.CachedInputTokens, u.OutputTokens)
+		uncachedCost := price.BilledCost(u.InputTokens, 0, u.OutputTokens)
+		// Cache savings are taken at list rates, before the free-token
+		// allowance, which only reduces the billed total.
+		summary.CacheSavings += uncachedCost - callCost
+		if _, seen := freeLeft[u.Model]; !seen {
+			freeLeft[u.Model] = pricing.freeTokensFor(u.Model, price)
+		}
+		if tokens := u.InputTokens + u.OutputTokens; freeLeft[u.Model] > 0 && tokens > 0 {
+			covered := min(freeLeft[u.Model], tokens)
+			freeLeft[u.Model] -= covered
+			summary.FreeTokensUsed += covered
+			callCost *= float64(tokens-covered) / float64(tokens)
+		}
+		callCost += pricing.PerCallFee
+		if price.EnergyWhPer1K > 0 {
+			summary.EnergyWh += float64(u.InputTokens+u.OutputTokens) / 1000 * price.EnergyWhPer1K
+		} else {
+			summary.EnergyUnknownCalls++
+		}
+		summary.TotalCost += callCost
+		d := callCost - summary.CallCostMean
+		summary.CallCostMean += d / float64(summary.CallCount)
+		summary.CallCostM2 += d * (callCost - summary.CallCostMean)
+		summary.ModelCosts[key] += callCost
+		label := safeValue(strings.TrimSpace(u.Label), unlabeled)
+		summary.Labels[label]++
+		summary.LabelCosts[label] += callCost
+		summary.TotalCachedInputTokens += min(u.CachedInputTokens, u.InputTokens)
+		if u.SystemTokens+u.UserTokens+u.AssistantTokens > 0 {
+			summary.SystemTokens += u.SystemTokens
+			summary.UserTokens += u.UserTokens
+			summary.AssistantTokens += u.AssistantTokens
+			summary.RoleCalls++
+		}
+		summary.MaxCacheSavings += uncachedCost - price.BilledCost(u.InputTokens, u.InputTokens, u.OutputTokens)
+		if summary.MaxCall == nil || callCost > summary.MaxCall.Cost {
+			summary.MaxCall = &MeasuredCall{Model: key, InputTokens: u.InputTokens, OutputTokens: u.OutputTokens, Cost: callCost}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if scanner.Oversized > 0 {
+		fmt.Fprintf(os.Stderr, "warn: %s: skipped %d line(s) over %d MiB\n", path, scanner.Oversized, maxMeasuredLine>>20)
+		summary.SkippedLines += scanner.Oversized
+	}
+
+	if total := summary.CallCount + summary.SkippedLines; maxSkipRatio >= 0 && total > 0 {
+		if ratio := float64(summary.SkippedLines) / float64(total); ratio > maxSkipRatio {
+			return nil, fmt.Errorf("%s: %d of %d lines malformed (%.1f%%), strict mode allows %.1f%%",
+				path, summary.SkippedLines, total, ratio*100, maxSkipRatio*100)
+		}
+	}
+	if malformed := summary.SkippedLines - scanner.Oversized; malformed > 0 {
+		fmt.Fprintf(os.Stderr, "warn: %s: skipped %d malformed line(s)\n", path, malformed)
+	}
+	if redacted > 0 {
+		fmt.Fprintf(os.Stderr, "warn: %s: redacted free-text provider, model or label values on %d line(s)\n", path, redacted)
+	}
+
+	if summary.CallCount == 0 {
+		return nil, nil
+	}
+	return summary, nil
+}
+
+// loadFilesFixture reads a JSON array shaped like the GitHub pull request
+// files API response, so the pipeline can run without network access.
+func loadFilesFixture(path string) ([]ghFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []ghFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return files, nil
+}
+
+// loadDiffFile reads a unified diff (e.g. `git diff base...head`) from path.
+func loadDiffFile(path string) ([]ghFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	files, err := parseUnifiedDiff(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return files, nil
+}
+
+// parseUnifiedDiff splits a unified diff on its `diff --git` headers into
+// files shaped like the pull request files API: the new path (the old one for
+// deletions) and the hunks from the first @@ line on. Binary files keep an
+// empty patch.
+func parseUnifiedDiff(r io.Reader) ([]ghFile, error) {
+	var (
+		files  []ghFile
+		cur    *ghFile
+		patch  []string
+		inHunk bool
+	)
+	flush := func() {
+		if cur != nil {
+			cur.Patch = strings.Join(patch, "\n")
+			files = append(files, *cur)
+		}
+		cur, patch, inHunk = nil, nil, false
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			cur = &ghFile{}
+			// "diff --git a/x b/y": the b/ side is the best guess until the
+			// ---/+++ headers say otherwise.
+			if i := strings.LastIndex(line, " b/"); i >= 0 {
+				cur.Filename = line[i+len(" b/"):]
+			}
+		case cur == nil:
+			// Preamble such as a commit message from git format-patch.
+		case inHunk:
+			patch = append(patch, line)
+		case strings.HasPrefix(line, "@@"):
+			inHunk = true
+			patch = append(patch, line)
+		case strings.HasPrefix(line, "+++ "):
+			if name := diffHeaderPath(line); name != "" {
+				cur.Filename = name
+			}
+		case strings.HasPrefix(line, "--- "):
+			if name := diffHeaderPath(line); name != "" {
+				cur.Filename = name
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	if len(files) == 0 {
+		return nil, errors.New("no `diff --git` headers found")
+	}
+	return files, nil
+}
+
+// diffHeaderPath returns the path from a ---/+++ header, without its a/ or
+// b/ prefix, or "" for /dev/null.
+func diffHeaderPath(line string) string {
+	name := strings.TrimSpace(line[len("+++ "):])
+	if i := strings.IndexByte(name, '\t'); i >= 0 {
+		name = name[:i]
+	}
+	if name == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(name, "a/") || strings.HasPrefix(name, "b/") {
+		name = name[2:]
+	}
+	return name
+}
+
+func readPRNumber(eventPath string) (int, error) {
+	ev, err := readEvent(eventPath)
+	if err != nil {
+		return 0, err
+	}
+
+	if ev.PullRequest.Number != 0 {
+		return ev.PullRequest.Number, nil
+	}
+	if ev.Issue.Number != 0 && ev.Issue.PullRequest != nil {
+		return ev.Issue.Number, nil
+	}
+	if ev.Number != 0 {
+		return ev.Number, nil
+	}
+	if ref := os.Getenv("GITHUB_REF"); strings.HasPrefix(ref, "refs/pull/") {
+		parts := strings.Split(ref, "/")
+		if len(parts) >= 3 {
+			if n, err := strconv.Atoi(parts[2]); err == nil && n > 0 {
+				return n, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+func readEvent(eventPath string) (ghEvent, error) {
+	var ev ghEvent
+	data, err := os.ReadFile(eventPath)
+	if err != nil {
+		return ev, err
+	}
+	if err = json.Unmarshal(data, &ev); err != nil {
+		return ev, err
+	}
+	if ev.PullRequest.Number == 0 && commentPR != nil {
+		err = json.Unmarshal(commentPR, &ev.PullRequest)
+	}
+	return ev, err
+}
+
+// isRecomputeComment reports whether an issue_comment event is a newly
+// created recomputeCommand on a pull request. The command must be the
+// comment's first line; case and extra spaces don't matter.
+func isRecomputeComment(eventPath string) bool {
+	ev, err := readEvent(eventPath)
+	if err != nil || ev.Issue.PullRequest == nil || ev.Action != "created" {
+		return false
+	}
+	first, _, _ := strings.Cut(strings.TrimSpace(ev.Comment.Body), "\n")
+	return strings.EqualFold(strings.Join(strings.Fields(first), " "), recomputeCommand)
+}
+
+// fetchPullRequest returns the raw pull request object, in the shape of an
+// event payload's pull_request field.
+func fetchPullRequest(ctx context.Context, client *http.Client, repo string, prNumber int) ([]byte, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/pulls/%d", repo, prNumber)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, statusError("get pull request", resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// readPRShas returns the base and head commit SHAs of a pull_request event.
+// Other event types yield empty strings.
+func readPRShas(eventPath string) (string, string) {
+	ev, err := readEvent(eventPath)
+	if err != nil {
+		return "", ""
+	}
+	return ev.PullRequest.Base.SHA, ev.PullRequest.Head.SHA
+}
+
+// readPRAuthor returns the login of the pull request's author, or "" when
+// the payload has none.
+func readPRAuthor(eventPath string) string {
+	ev, err := readEvent(eventPath)
+	if err != nil {
+		return ""
+	}
+	return ev.PullRequest.User.Login
+}
+
+// readPRLabels returns the label names of a pull_request event, or nil when
+// the payload carries no pull request labels.
+func readPRLabels(eventPath string) []string {
+	ev, err := readEvent(eventPath)
+	if err != nil || ev.PullRequest.Labels == nil {
+		return nil
+	}
+	names := make([]string, 0, len(ev.PullRequest.Labels))
+	for _, l := range ev.PullRequest.Labels {
+		names = append(names, l.Name)
+	}
+	return names
+}
+
+// errNoPermission marks GitHub API failures caused by the token's scope.
+var errNoPermission = errors.New("insufficient token permissions")
+
+// statusError converts an HTTP error status into an error, wrapping
+// errNoPermission for 401/403 so callers can degrade gracefully.
+func statusError(op string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%s: %s: %w", op, resp.Status, errNoPermission)
+	}
+	return fmt.Errorf("%s: %s", op, resp.Status)
+}
+
+// isForkPR reports a pull_request run from a fork, which always gets a
+// read-only token.
+func isForkPR(eventPath string) bool {
+	if os.Getenv("GITHUB_EVENT_NAME") != "pull_request" {
+		return false
+	}
+	ev, err := readEvent(eventPath)
+	return err == nil && ev.PullRequest.Head.Repo.Fork
+}
+
+// commentSkippedNote tells job summary readers why the PR has no comment.
+func commentSkippedNote(reason string) string {
+	return fmt.Sprintf("\n_ℹ️ PR comment skipped: %s._\n", reason)
+}
+
+// appendSummary adds text to the job summary already written for this run,
+// or prints it when there is no summary file.
+func appendSummary(text string) {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		fmt.Println(text)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err == nil {
+		_, err = f.WriteString(text)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warn: cannot update job summary: %v\n", err)
+	}
+}
+
+// newGHClient authenticates with token, or with installation tokens of
+// githubApp when Plarix runs as a GitHub App.
+func newGHClient(token string) *http.Client {
+	return &http.Client{Timeout: 15 * time.Second, Transport: &authTransport{token: token, app: githubApp}}
+}
+
+type authTransport struct {
+	token string
+	app   *appAuth
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := t.token
+	if t.app != nil {
+		var err error
+		if token, err = t.app.Token(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/vnd.github+json")
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", defaultUserAgent)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// githubApp is set when PLARIX_APP_ID, PLARIX_APP_PRIVATE_KEY and
+// PLARIX_APP_INSTALLATION_ID are configured; it replaces GITHUB_TOKEN.
+var githubApp *appAuth
+
+// appAuth mints GitHub App installation tokens and refreshes them shortly
+// before they expire (GitHub issues them for an hour).
+type appAuth struct {
+	appID          string
+	installationID string
+	key            *rsa.PrivateKey
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// loadAppAuth reads the GitHub App settings. It returns nil when none are
+// set and an error when they are incomplete or the key is unusable.
+func loadAppAuth() (*appAuth, error) {
+	appID := strings.TrimSpace(os.Getenv("PLARIX_APP_ID"))
+	installationID := strings.TrimSpace(os.Getenv("PLARIX_APP_INSTALLATION_ID"))
+	// Secrets pasted into a single line often carry literal \n escapes.
+	pemKey := strings.ReplaceAll(strings.TrimSpace(os.Getenv("PLARIX_APP_PRIVATE_KEY")), `\n`, "\n")
+	if appID == "" && installationID == "" && pemKey == "" {
+		return nil, nil
+	}
+	if appID == "" || installationID == "" || pemKey == "" {
+		return nil, errors.New("PLARIX_APP_ID, PLARIX_APP_PRIVATE_KEY and PLARIX_APP_INSTALLATION_ID must all be set")
+	}
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("PLARIX_APP_PRIVATE_KEY is not a PEM private key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err8 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if err8 != nil || !ok {
+			return nil, fmt.Errorf("PLARIX_APP_PRIVATE_KEY: %w", err)
+		}
+		key = rsaKey
+	}
+	return &appAuth{appID: appID, installationID: installationID, key: key}, nil
+}
+
+// Token returns a valid installation token, minting a new one when the
+// current token is missing or expires within five minutes.
+func (a *appAuth) Token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && time.Until(a.expires) > 5*time.Minute {
+		return a.token, nil
+	}
+	jwt, err := a.jwt(time.Now())
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("https://api.github.com/app/installations/%s/access_tokens", a.installationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", defaultUserAgent)
+	resp, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("minting installation token: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var out struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("minting installation token: %w", err)
+	}
+	a.token, a.expires = out.Token, out.ExpiresAt
+	return a.token, nil
+}
+
+// jwt signs the short-lived RS256 token that authenticates as the App. iat
+// is backdated a minute to tolerate clock drift, as GitHub recommends.
+func (a *appAuth) jwt(now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := header + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + enc.EncodeToString(sig), nil
+}
+
+// fetchPRFiles pages through the PR's files until a short page arrives or
+// maxFiles is reached. truncated reports whether files were left unread.
+func fetchPRFiles(ctx context.Context, client *http.Client, repo string, prNumber, maxFiles int) (all []ghFile, truncated bool, err error) {
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("https://api.github.com/repos/%s/pulls/%d/files?per_page=100&page=%d", repo, prNumber, page)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, false, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, false, err
+		}
+		if resp.StatusCode >= 400 {
+			return nil, false, fmt.Errorf("github api: %s", resp.Status)
+		}
+		var files []ghFile
+		if err := json.Unmarshal(body, &files); err != nil {
+			return nil, false, err
+		}
+		all = append(all, files...)
+		if len(files) < 100 {
+			return all, false, nil
+		}
+		if len(all) >= maxFiles {
+			return all[:maxFiles], true, nil
+		}
+	}
+}
+
+// isDraftPR reports whether the PR is a draft, from the pull_request payload
+// or, for other events, the pulls API. Errors count as not a draft.
+func isDraftPR(ctx context.Context, client *http.Client, repo string, prNumber int, eventPath string) bool {
+	if ev, err := readEvent(eventPath); err == nil && ev.PullRequest.Draft != nil {
+		return *ev.PullRequest.Draft
+	}
+	url := fmt.Sprintf("https://api.github.com/repos/%s/pulls/%d", repo, prNumber)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	var pr struct {
+		Draft bool `json:"draft"`
+	}
+	if resp.StatusCode >= 400 || json.NewDecoder(resp.Body).Decode(&pr) != nil {
+		return false
+	}
+	return pr.Draft
+}
+
+// draftBody folds a report into a collapsed block for draft PRs, keeping
+// commentMarker outside it so later runs still find the comment.
+func draftBody(report string) string {
+	return fmt.Sprintf("%s\n\n<details>\n<summary>📝 Draft — analysis deferred (expand for the report)</summary>\n\n%s\n\n</details>\n", commentMarker, stripMarker(report))
+}
+
+func fetchPRLabels(ctx context.Context, client *http.Client, repo string, prNumber int) ([]string, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/issues/%d/labels?per_page=100", repo, prNumber)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("github api: %s", resp.Status)
+	}
+	var labels []ghLabel
+	if err := json.NewDecoder(resp.Body).Decode(&labels); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(labels))
+	for _, l := range labels {
+		names = append(names, l.Name)
+	}
+	return names, nil
+}
+
+func configChanged(files []ghFile) bool {
+	for _, f := range files {
+		if f.Filename == configPath {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchConfigAt reads .plarix.yml at the given ref via the contents API.
+// A missing file is reported as found=false rather than an error.
+func fetchConfigAt(ctx context.Context, client *http.Client, repo, ref string) (Config, bool, error) {
+	cfg := defaultConfig()
+	raw, found, err := fetchFileAt(ctx, client, repo, configPath, ref)
+	if err != nil || !found {
+		return cfg, false, err
+	}
+	parseConfig(bytes.NewReader(raw), &cfg)
+	return cfg, true, nil
+}
+
+// fetchFileAt reads a repository file at ref through the contents API. The
+// raw media type lifts the 1 MB limit of base64 responses, which measured
+// logs can exceed.
+func fetchFileAt(ctx context.Context, client *http.Client, repo, filePath, ref string) ([]byte, bool, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/contents/%s?ref=%s", repo, filePath, ref)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req.Header.Set("Accept", "application/vnd.github.raw+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if resp.StatusCode >= 400 {
+		return nil, false, fmt.Errorf("github api: %s", resp.Status)
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	return raw, true, nil
+}
+
+// repoRelative turns a path under GITHUB_WORKSPACE into the repository path
+// the contents API expects.
+func repoRelative(p string) string {
+	if ws := os.Getenv("GITHUB_WORKSPACE"); ws != "" && filepath.IsAbs(p) {
+		if rel, err := filepath.Rel(ws, p); err == nil && !strings.HasPrefix(rel, "..") {
+			p = rel
+		}
+	}
+	return filepath.ToSlash(filepath.Clean(p))
+}
+
+var (
+	modelPattern     = regexp.MustCompile(`(?i)\b((?:openai|anthropic|google)/[\w.-]+|gpt-[\w.-]+|claude-[\w.-]+|gemini-[\w.-]+)\b`)
+	maxTokensPattern = maxTokensKeyPattern(nil)
+	contextPattern   = regexp.MustCompile(`(?i)\b(?:context[_-]?(?:window|length|size)|max[_-]?context[_-]?(?:tokens|length)?|max[_-]?input[_-]?tokens|num[_-]?ctx)["']?\s*[:=]\s*([0-9][0-9_]*(?:\.[0-9]+)?(?:e[+-]?[0-9]+)?)`)
+	callSitePattern  = regexp.MustCompile(`(?i)\b(?:chat\.completions|completions|messages|responses)\.create\b|\bgenerate_?content\b`)
+	retryPattern     = retryKeyPattern(nil)
+	providerPattern  = regexp.MustCompile(`(?i)\bprovider\s*[:=]\s*["']?([a-z][\w-]*)`)
+	// baseURLPattern finds endpoint settings with a literal URL value, e.g.
+	// base_url="https://proxy.internal/v1" or OPENAI_BASE_URL: https://...
+	baseURLPattern = regexp.MustCompile(`(?i)(?:base_?url|api_?base(?:_?url)?|azure_endpoint)["']?\s*[:=]\s*["']?(https?://[^"'\s,)]+)`)
+	// concurrencyPattern finds worker and parallelism settings, which scale
+	// throughput and so cost.
+	concurrencyPattern = regexp.MustCompile(`(?i)\b(?:max[_-]?concurrency|concurrency(?:[_-]?limit)?|max[_-]?workers|num[_-]?workers|workers|parallelism|max[_-]?parallel(?:ism)?)["']?\s*[:=]\s*([0-9][0-9_]*)\b`)
+	// modelWeightPattern finds a quoted model name mapped to a number, as in
+	// a weighted router's {"gpt-4o": 0.2, "gpt-4o-mini": 0.8}.
+	modelWeightPattern = regexp.MustCompile(`(?i)["']((?:openai|anthropic|google)/[\w.-]+|gpt-[\w.-]+|claude-[\w.-]+|gemini-[\w.-]+)["']\s*[:=]\s*([0-9]*\.?[0-9]+)\b`)
+	// structuredKeyPattern splits a JSON ("key": value) or YAML (key: value,
+	// - key: value) line into key and value.
+	structuredKeyPattern = regexp.MustCompile(`^\s*(?:-\s+)?["']?([\w.-]+)["']?\s*:\s*(.*)$`)
+)
+
+// maxTokensKeyPattern matches an output-limit setting named by the built-in
+// spellings or one of extra, capturing its value.
+func maxTokensKeyPattern(extra []string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(?:max[_-]?(?:output[_-]?)?tokens` + keyAlternatives(extra) + `)\s*[:=]\s*([0-9][0-9_]*(?:\.[0-9]+)?(?:e[+-]?[0-9]+)?)`)
+}
+
+// retryKeyPattern matches a retry setting named by the built-in spellings
+// or one of extra, capturing the key and its value.
+func retryKeyPattern(extra []string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(retries|maxRetries|retry\s*count|retry_limit` + keyAlternatives(extra) + `)\s*[:=]\s*([0-9]+)`)
+}
+
+// keyAlternatives turns literal key names into extra regexp alternatives.
+func keyAlternatives(keys []string) string {
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString("|" + regexp.QuoteMeta(k))
+	}
+	return b.String()
+}
+
+// useSignalKeys recompiles the retry and max_tokens patterns with the keys
+// from the signals section of .plarix.yml.
+func useSignalKeys(cfg Config) {
+	if len(cfg.RetryKeys) > 0 {
+		retryPattern = retryKeyPattern(cfg.RetryKeys)
+	}
+	if len(cfg.MaxTokensKeys) > 0 {
+		maxTokensPattern = maxTokensKeyPattern(cfg.MaxTokensKeys)
+	}
+}
+
+// structuredModelKeys are the config keys whose value names a model in
+// JSON and YAML files.
+var structuredModelKeys = map[string]bool{"model": true, "model_name": true, "deployment": true, "engine": true}
+
+// isStructuredFile reports whether name is a JSON or YAML file, whose model
+// signals come from key-value pairs rather than free text.
+func isStructuredFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// lineModels returns the model names on a diff line. In structured files
+// only the value of a model-related key counts, so comments and unrelated
+// strings that mention a model are ignored.
+func lineModels(line string, structured bool) []string {
+	if !structured {
+		return modelPattern.FindAllString(line, -1)
+	}
+	m := structuredKeyPattern.FindStringSubmatch(line)
+	if m == nil || !structuredModelKeys[strings.ToLower(m[1])] {
+		return nil
+	}
+	val := m[2]
+	if i := strings.Index(val, " #"); i >= 0 {
+		val = val[:i]
+	}
+	val = strings.Trim(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(val), ",")), "\"'")
+	if val == "" || val == "null" || strings.ContainsAny(val, "{[") {
+		return nil
+	}
+	return []string{val}
+}
+
+// defaultExcludedFiles are lockfiles, minified bundles and binary-ish assets
+// whose contents mention model names without being call sites.
+var defaultExcludedFiles = []string{
+	".lock", "-lock.json", "-lock.yaml", "go.sum",
+	".min.js", ".min.css", ".map",
+	".png", ".jpg", ".jpeg", ".gif", ".ico", ".pdf", ".zip", ".gz", ".woff", ".woff2", ".ttf",
+}
+
+// FileFilter limits which PR files are scanned for signals. Entries are
+// matched as case-insensitive suffixes of the file name, so ".py", ".min.js"
+// and "go.sum" all work.
+type FileFilter struct {
+	Include []string // when non-empty, only matching files are scanned
+	Exclude []string // added to defaultExcludedFiles
+}
+
+// Allows reports whether a file should be scanned.
+func (f FileFilter) Allows(name string) bool {
+	name = strings.ToLower(name)
+	if hasAnySuffix(name, defaultExcludedFiles) || hasAnySuffix(name, f.Exclude) {
+		return false
+	}
+	return len(f.Include) == 0 || hasAnySuffix(name, f.Include)
+}
+
+func hasAnySuffix(name string, suffixes []string) bool {
+	for _, s := range suffixes {
+		if s != "" && strings.HasSuffix(name, strings.ToLower(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sdkPatterns detect a provider from its SDK's import path, so switching SDKs
+// counts as a provider change even when no model name is touched.
+var sdkPatterns = []struct {
+	re       *regexp.Regexp
+	provider string
+}{
+	{regexp.MustCompile(`\b(?:import|from)\s+anthropic\b|@anthropic-ai/sdk|github\.com/anthropics/anthropic-sdk-go`), "anthropic"},
+	{regexp.MustCompile(`\b(?:import|from)\s+openai\b|["']openai["']|github\.com/openai/openai-go|github\.com/sashabaranov/go-openai`), "openai"},
+	{regexp.MustCompile(`\bgoogle\.generativeai\b|\bfrom\s+google\s+import\s+genai\b|@google/genai|@google/generative-ai|google\.golang\.org/genai`), "google"},
+}
+
+var (
+	// promptStartPattern finds assignments such as systemPrompt := ...,
+	// SYSTEM_PROMPT = ..., system=... and system: ...; group 1 is the value.
+	promptStartPattern = regexp.MustCompile(`(?i)\b(?:system_?prompt|system_?message|system_?instructions?|system)\s*(?::=|=|:)\s*(.*)$`)
+	// promptOpenPattern matches the start of a string literal (with Python
+	// prefixes like r or f) or of a parenthesized concatenation.
+	promptOpenPattern = regexp.MustCompile("^[rRfFbBuU]{0,2}(\"\"\"|'''|`|\"|')|^\\(")
+)
+
+// promptScanner follows one side of a patch (context lines plus either the
+// removed or the added lines) through system-prompt string literals.
+type promptScanner struct {
+	closer string // ends the current literal; "+" while a concatenation continues
+}
+
+// feed consumes one line and returns how many of its characters belong to a
+// system prompt. Multi-line forms are triple-quoted and backtick raw strings,
+// parenthesized implicit concatenation, and lines ending in + or \.
+func (p *promptScanner) feed(line string) int {
+	text := strings.TrimSpace(line)
+	if p.closer == "" {
+		m := promptStartPattern.FindStringSubmatch(text)
+		if m == nil {
+			return 0
+		}
+		open := promptOpenPattern.FindStringSubmatch(m[1])
+		if open == nil {
+			return 0
+		}
+		body := m[1][len(open[0]):]
+		switch delim := open[1]; delim {
+		case "":
+			if !strings.Contains(body, ")") {
+				p.closer = ")"
+			}
+		case `"""`, "'''", "`":
+			if !strings.Contains(body, delim) {
+				p.closer = delim
+			}
+		}
+		if p.closer == "" && continuesLine(body) {
+			p.closer = "+"
+		}
+		return utf8.RuneCountInString(m[1])
+	}
+	if p.closer == "+" {
+		if !continuesLine(text) {
+			p.closer = ""
+		}
+	} else if strings.Contains(text, p.closer) {
+		p.closer = ""
+	}
+	return utf8.RuneCountInString(text)
+}
+
+func continuesLine(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.HasSuffix(s, "+") || strings.HasSuffix(s, "\\")
+}
+
+// knownProviders are the provider values accepted from diff lines.
+var knownProviders = map[string]bool{"openai": true, "anthropic": true, "google": true, "openrouter": true}
+
+func extractSignals(files []ghFile, filter FileFilter) DiffSignals {
+	var s DiffSignals
+	for _, f := range files {
+		if !filter.Allows(f.Filename) || !hasContentChanges(f.Patch) {
+			continue
+		}
+		// Each side of the patch is tracked separately so a literal whose
+		// closing line was edited still ends on both sides.
+		var beforePrompt, afterPrompt promptScanner
+		structured := isStructuredFile(f.Filename)
+		var hunkModels, hunkMax []signalSite
+		var beforeWeights, afterWeights weightScanner
+		newLine := 0 // head-side line number of the current patch line
+		scanner := bufio.NewScanner(strings.NewReader(f.Patch))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "@@") {
+				// Hunks skip lines, so any open literal is lost.
+				beforePrompt, afterPrompt = promptScanner{}, promptScanner{}
+				beforeWeights.flush()
+				afterWeights.flush()
+				s.MaxTokenPairs = append(s.MaxTokenPairs, nearestModelPairs(hunkMax, hunkModels)...)
+				hunkModels, hunkMax = nil, nil
+				if m := hunkHeaderPattern.FindStringSubmatch(line); m != nil {
+					newLine, _ = strconv.Atoi(m[1])
+				}
+				continue
+			}
+			if strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") {
+				continue
+			}
+			lineNo := newLine
+			switch {
+			case strings.HasPrefix(line, "-"):
+				s.PromptCharsRemoved += beforePrompt.feed(line[1:])
+				s.CallSitesRemoved += len(callSitePattern.FindAllString(line, -1))
+				beforeWeights.feed(line)
+			case strings.HasPrefix(line, "+"):
+				s.PromptCharsAdded += afterPrompt.feed(line[1:])
+				s.CallSitesAdded += len(callSitePattern.FindAllString(line, -1))
+				afterWeights.feed(line)
+				newLine++
+			case strings.HasPrefix(line, " "):
+				beforePrompt.feed(line[1:])
+				afterPrompt.feed(line[1:])
+				for _, m := range lineModels(line[1:], structured) {
+					hunkModels = append(hunkModels, signalSite{Line: lineNo, Model: m})
+				}
+				beforeWeights.feed(line)
+				afterWeights.feed(line)
+				newLine++
+			}
+			var targetModels, targetProviders, targetBaseURLs *[]string
+			var targetMax *[]int
+			var targetRetry, targetContext, targetConcurrency *[]int
+			if strings.HasPrefix(line, "-") {
+				targetModels = &s.BeforeModels
+				targetProviders = &s.BeforeProviders
+				targetMax = &s.BeforeMax
+				targetRetry = &s.BeforeRetry
+				targetContext = &s.BeforeContext
+				targetBaseURLs = &s.BeforeBaseURLs
+				targetConcurrency = &s.BeforeConcurrency
+			} else if strings.HasPrefix(line, "+") {
+				targetModels = &s.AfterModels
+				targetProviders = &s.AfterProviders
+				targetMax = &s.AfterMax
+				targetRetry = &s.AfterRetry
+				targetContext = &s.AfterContext
+				targetBaseURLs = &s.AfterBaseURLs
+				targetConcurrency = &s.AfterConcurrency
+			} else {
+				continue
+			}
+
+			*targetModels = append(*targetModels, lineModels(line[1:], structured)...)
+			for _, m := range providerPattern.FindAllStringSubmatch(line, -1) {
+				if p := canonicalProvider(m[1]); knownProviders[p] {
+					*targetProviders = append(*targetProviders, p)
+				}
+			}
+			for _, sdk := range sdkPatterns {
+				if sdk.re.MatchString(line) {
+					*targetProviders = append(*targetProviders, sdk.provider)
+				}
+			}
+			for _, m := range baseURLPattern.FindAllStringSubmatch(line, -1) {
+				*targetBaseURLs = append(*targetBaseURLs, strings.TrimRight(m[1], "/"))
+			}
+			for _, m := range maxTokensPattern.FindAllStringSubmatch(line, -1) {
+				if v, ok := parseTokenCount(m[1]); ok {
+					*targetMax = append(*targetMax, v)
+				}
+			}
+			for _, m := range contextPattern.FindAllStringSubmatch(line, -1) {
+				if v, ok := parseTokenCount(m[1]); ok {
+					*targetContext = append(*targetContext, v)
+				}
+			}
+			for _, m := range concurrencyPattern.FindAllStringSubmatch(line, -1) {
+				if v, ok := parseTokenCount(m[1]); ok && v > 0 {
+					*targetConcurrency = append(*targetConcurrency, v)
+				}
+			}
+			for _, m := range retryPattern.FindAllStringSubmatch(line, -1) {
+				if v, err := strconv.Atoi(m[2]); err == nil {
+					*targetRetry = append(*targetRetry, v)
+				}
+			}
+			if strings.HasPrefix(line, "+") && lineNo > 0 {
+				site := signalSite{File: f.Filename, Line: lineNo}
+				if models := lineModels(line[1:], structured); len(models) > 0 {
+					site.Model = models[0]
+				}
+				if m := maxTokensPattern.FindStringSubmatch(line); m != nil {
+					site.MaxTokens, _ = parseTokenCount(m[1])
+				}
+				if site.Model != "" || site.MaxTokens > 0 {
+					s.Sites = append(s.Sites, site)
+				}
+				for _, m := range lineModels(line[1:], structured) {
+					hunkModels = append(hunkModels, signalSite{Line: lineNo, Model: m})
+				}
+				for _, m := range maxTokensPattern.FindAllStringSubmatch(line, -1) {
+					if v, ok := parseTokenCount(m[1]); ok {
+						hunkMax = append(hunkMax, signalSite{File: f.Filename, Line: lineNo, MaxTokens: v})
+					}
+				}
+			}
+		}
+		s.MaxTokenPairs = append(s.MaxTokenPairs, nearestModelPairs(hunkMax, hunkModels)...)
+		beforeWeights.flush()
+		afterWeights.flush()
+		s.BeforeWeights = append(s.BeforeWeights, beforeWeights.maps...)
+		s.AfterWeights = append(s.AfterWeights, afterWeights.maps...)
+	}
+	return dropUnchanged(s)
+}
+
+// nearestModelPairs gives each max_tokens site the model named closest to it
+// in the same hunk, the earlier line winning ties, so a file calling two
+// models pairs each limit with its own call. Sites with no model are dropped.
+func nearestModelPairs(maxSites, models []signalSite) []signalSite {
+	var out []signalSite
+	for _, site := range maxSites {
+		distance := func(m signalSite) int {
+			if m.Line < site.Line {
+				return site.Line - m.Line
+			}
+			return m.Line - site.Line
+		}
+		best := -1
+		for i, m := range models {
+			if best < 0 || distance(m) < distance(models[best]) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			site.Model = models[best].Model
+			out = append(out, site)
+		}
+	}
+	return out
+}
+
+// weightScanner follows one side of a patch, like promptScanner, collecting
+// routing maps. A map is a single line with several entries or a run of
+// consecutive lines with one entry each.
+type weightScanner struct {
+	run  []modelWeight
+	maps []modelWeight
+}
+
+func (w *weightScanner) feed(line string) {
+	weights := lineWeights(line)
+	if len(weights) != 1 {
+		w.flush()
+	}
+	w.run = append(w.run, weights...)
+	if len(weights) > 1 {
+		w.flush()
+	}
+}
+
+// flush ends the current run, keeping it if it is a routing map.
+func (w *weightScanner) flush() {
+	if isRoutingMap(w.run) {
+		w.maps = append(w.maps, w.run...)
+	}
+	w.run = nil
+}
+
+// lineWeights returns the model-to-number pairs on a diff line.
+func lineWeights(line string) []modelWeight {
+	var weights []modelWeight
+	for _, m := range modelWeightPattern.FindAllStringSubmatch(line, -1) {
+		if v, err := strconv.ParseFloat(m[2], 64); err == nil {
+			weights = append(weights, modelWeight{Model: m[1], Weight: v})
+		}
+	}
+	return weights
+}
+
+// isRoutingMap tells a router's weights from other per-model numbers (token
+// limits, context sizes): at least two distinct models whose weights sum to
+// 1 or to 100.
+func isRoutingMap(weights []modelWeight) bool {
+	models := make(map[string]bool, len(weights))
+	var sum float64
+	for _, w := range weights {
+		models[strings.ToLower(w.Model)] = true
+		sum += w.Weight
+	}
+	return len(models) >= 2 && len(models) == len(weights) &&
+		(math.Abs(sum-1) < 0.01 || math.Abs(sum-100) < 1)
+}
+
+// hasContentChanges reports whether patch changes anything beyond
+// whitespace: it is false for an empty patch, one with only hunk headers and
+// context, and a reindent or trailing-space fix whose removed and added lines
+// match once whitespace is stripped.
+func hasContentChanges(patch string) bool {
+	pending := make(map[string]int) // stripped line -> added minus removed
+	for _, line := range strings.Split(patch, "\n") {
+		if line == "" || strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") {
+			continue
+		}
+		var delta int
+		switch line[0] {
+		case '+':
+			delta = 1
+		case '-':
+			delta = -1
+		default:
+			continue
+		}
+		key := strings.Join(strings.Fields(line[1:]), "")
+		if key == "" {
+			continue
+		}
+		if pending[key] += delta; pending[key] == 0 {
+			delete(pending, key)
+		}
+	}
+	return len(pending) > 0
+}
+
+// dropUnchanged clears signal dimensions whose removed and added values are
+// the same set, as when a line is reformatted without changing its value,
+// so the report doesn't show no-ops like "gpt-4o → gpt-4o".
+func dropUnchanged(s DiffSignals) DiffSignals {
+	sameModels := sameSet(s.BeforeModels, s.AfterModels)
+	if sameModels {
+		s.BeforeModels, s.AfterModels = nil, nil
+	}
+	if sameSet(s.BeforeProviders, s.AfterProviders) {
+		s.BeforeProviders, s.AfterProviders = nil, nil
+	}
+	sameMax := sameSet(s.BeforeMax, s.AfterMax)
+	if sameMax {
+		s.BeforeMax, s.AfterMax = nil, nil
+		// A limit the PR only moved was checked when it was first added.
+		s.MaxTokenPairs = nil
+	}
+	if sameSet(s.BeforeRetry, s.AfterRetry) {
+		s.BeforeRetry, s.AfterRetry = nil, nil
+	}
+	if sameSet(s.BeforeContext, s.AfterContext) {
+		s.BeforeContext, s.AfterContext = nil, nil
+	}
+	if sameSet(s.BeforeConcurrency, s.AfterConcurrency) {
+		s.BeforeConcurrency, s.AfterConcurrency = nil, nil
+	}
+	if sameSet(s.BeforeBaseURLs, s.AfterBaseURLs) {
+		s.BeforeBaseURLs, s.AfterBaseURLs = nil, nil
+	}
+	if sameSet(s.BeforeWeights, s.AfterWeights) {
+		s.BeforeWeights, s.AfterWeights = nil, nil
+	}
+	// A call moved or reformatted shows up on both sides.
+	if s.CallSitesRemoved == s.CallSitesAdded {
+		s.CallSitesRemoved, s.CallSitesAdded = 0, 0
+	}
+	var sites []signalSite
+	for _, site := range s.Sites {
+		if sameModels {
+			site.Model = ""
+		}
+		if sameMax {
+			site.MaxTokens = 0
+		}
+		if site.Model != "" || site.MaxTokens > 0 {
+			sites = append(sites, site)
+		}
+	}
+	s.Sites = sites
+	return s
+}
+
+// sameSet reports whether a and b hold the same distinct values.
+func sameSet[T comparable](a, b []T) bool {
+	set := make(map[T]bool, len(a))
+	for _, v := range a {
+		set[v] = true
+	}
+	seen := make(map[T]bool, len(b))
+	for _, v := range b {
+		if !set[v] {
+			return false
+		}
+		seen[v] = true
+	}
+	return len(seen) == len(set)
+}
+
+// parseTokenCount normalizes numeric literals such as 4096, 4_096, 4096.0
+// and 1e4 to an int. Fractional values are rounded to the nearest token.
+func parseTokenCount(raw string) (int, bool) {
+	raw = strings.ReplaceAll(raw, "_", "")
+	if v, err := strconv.Atoi(raw); err == nil {
+		return v, true
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) || f < 0 || f > math.MaxInt32 {
+		return 0, false
+	}
+	return int(math.Round(f)), true
+}
+
+// countSignalFiles reports how many files contribute at least one signal.
+func countSignalFiles(files []ghFile, filter FileFilter) int {
+	n := 0
+	for _, f := range files {
+		if hasAnySignals(extractSignals([]ghFile{f}, filter)) {
+			n++
+		}
+	}
+	return n
+}
+
+func computeEstimate(a Assumptions, pricing PricingFile, model string) (costPair, bool) {
+	price, found := priceFor(pricing, a.Provider, model)
+	cached := int(math.Round(float64(a.AvgInputTokens) * a.CacheHitRate))
+	perRequest := price.CachedCost(a.AvgInputTokens, cached, a.AvgOutputTokens)
+	if strings.EqualFold(a.Provider, "openrouter") && a.OpenRouterMultiplier > 0 {
+		perRequest *= a.OpenRouterMultiplier
+	}
+	cost := costPair{PerRequest: perRequest, Monthly: perRequest * a.RequestsPerDay * 30, PerUnit: perRequest * a.UnitDivisor}
+	// The allowance is spread proportionally over input and output tokens;
+	// per-request cost stays at the marginal list rate.
+	if free := pricing.freeTokensFor(model, price); free > 0 {
+		used := float64(a.AvgInputTokens+a.AvgOutputTokens) * a.RequestsPerDay * 30
+		if used <= float64(free) {
+			cost.Monthly, cost.WithinFreeTier = 0, true
+		} else {
+			cost.Monthly *= (used - float64(free)) / used
+		}
+	}
+	// The fee is per call, so neither the OpenRouter markup nor free tokens
+	// apply to it.
+	cost.PerRequest += a.PerCallFee
+	cost.Monthly += a.PerCallFee * a.RequestsPerDay * 30
+	cost.PerUnit += a.PerCallFee * a.UnitDivisor
+	return cost, found
+}
+
+func priceFor(pricing PricingFile, provider, model string) (ModelPrice, bool) {
+	provider, model = splitModelID(provider, model)
+	candidates := []string{model}
+	// OpenRouter spells versions with dots (claude-3.5-sonnet) where the
+	// vendors use dashes (claude-3-5-sonnet).
+	if dashed := strings.ReplaceAll(model, ".", "-"); dashed != model {
+		candidates = append(candidates, dashed)
+	}
+	for _, name := range candidates {
+		for _, m := range pricing.Models {
+			if strings.EqualFold(m.Provider, provider) && strings.EqualFold(m.Name, name) {
+				return m, true
+			}
+		}
+	}
+	// Exact names take precedence; otherwise the longest matching pattern wins
+	// so "gpt-4o-mini-20*" beats a broader rule.
+	var best ModelPrice
+	found := false
+	for _, name := range candidates {
+		for _, m := range pricing.Models {
+			if m.NamePattern == "" || !strings.EqualFold(m.Provider, provider) {
+				continue
+			}
+			if ok, _ := path.Match(strings.ToLower(m.NamePattern), strings.ToLower(name)); ok && (!found || len(m.NamePattern) > len(best.NamePattern)) {
+				best, found = m, true
+			}
+		}
+		if found {
+			return best, true
+		}
+	}
+	if provider == "" {
+		return inferProvider(pricing, model)
+	}
+	return ModelPrice{Provider: provider, Name: model}, false
+}
+
+// providerLabel shows a configured provider, or notes that it is inferred.
+func providerLabel(provider string) string {
+	return safeValue(provider, "inferred from model")
+}
+
+// inferProvider prices a model given without a provider (e.g. a config with
+// only `model: gpt-4o`) under the one provider whose rows match it. When
+// several providers match, the model is left unpriced rather than guessed.
+func inferProvider(pricing PricingFile, model string) (ModelPrice, bool) {
+	var (
+		match     ModelPrice
+		providers []string
+	)
+	seen := map[string]bool{}
+	for _, m := range pricing.Models {
+		if m.Provider == "" || seen[m.Provider] {
+			continue
+		}
+		seen[m.Provider] = true
+		if price, found := priceFor(pricing, m.Provider, model); found {
+			match = price
+			providers = append(providers, m.Provider)
+		}
+	}
+	key := strings.ToLower(model)
+	switch {
+	case len(providers) == 1:
+		if pricing.warnOnce(key) {
+			fmt.Fprintf(os.Stderr, "warn: no provider given for %s; pricing it as %s, the only provider listing it\n", model, providers[0])
+		}
+		return match, true
+	case len(providers) > 1:
+		if pricing.warnOnce(key) {
+			fmt.Fprintf(os.Stderr, "warn: no provider given for %s and it is listed by %s; set provider to price it\n", model, strings.Join(providers, ", "))
+		}
+	}
+	return ModelPrice{Name: model}, false
+}
+
+// splitModelID derives provider and model from OpenRouter-style IDs such as
+// "openai/gpt-4o". The vendor prefix wins over the given provider, which lets
+// "openrouter" act as an alias for whichever vendor the ID names.
+func splitModelID(provider, model string) (string, string) {
+	if vendor, name, ok := strings.Cut(model, "/"); ok && vendor != "" && name != "" {
+		return canonicalProvider(vendor), name
+	}
+	return canonicalProvider(provider), model
+}
+
+// providerAliases maps common spellings to the provider names used in
+// pricing.json.
+var providerAliases = map[string]string{
+	"oai":          "openai",
+	"open-ai":      "openai",
+	"open_ai":      "openai",
+	"claude":       "anthropic",
+	"anthropic-ai": "anthropic",
+	"gemini":       "google",
+	"vertex":       "google",
+	"open-router":  "openrouter",
+}
+
+// canonicalProvider lowercases a provider name and resolves known aliases.
+func canonicalProvider(provider string) string {
+	p := strings.ToLower(strings.TrimSpace(provider))
+	if canonical, ok := providerAliases[p]; ok {
+		return canonical
+	}
+	return p
+}
+
+type reportInput struct {
+	ConfigFound    bool
+	Config         Assumptions
+	Pricing        PricingFile
+	Signals        DiffSignals
+	BaseConfig     *Assumptions // base-branch assumptions when the PR edits .plarix.yml
+	BaseRef        string       // tag or SHA used as Before instead of the merge target
+	DefaultModels  map[string]string
+	FilesChanged   int  // files in the PR diff
+	SignalFiles    int  // files whose patch produced at least one signal
+	Truncated      bool // PR files beyond PLARIX_MAX_FILES were not scanned
+	BaseMeasured   *MeasuredSummary
+	HeadMeasured   *MeasuredSummary
+	Budget         *budgetResult
+	OrgBudget      *budgetResult // this repo's line in PLARIX_BUDGET_URL
+	ShowPricing    bool          // append the pricing rows behind the numbers
+	ShowEnergy     bool          // add a rough energy and CO2e line to measured reports
+	SignalsSummary bool          // list only net-new and dropped models in the signals
+	Title          string        // overrides defaultTitle when set
+	Footer         string        // appended below the report when set
+	WatchedAdded   []string      // watch_models entries introduced by the PR
+	// GroupByFamily reports model families instead of SKUs, with Families
+	// (from .plarix.yml) overriding derivedFamily.
+	GroupByFamily bool
+	Families      map[string]string
+	MinCalls      int // measured logs with fewer calls are flagged as low sample
+	// ShowConfig appends the effective assumptions after parsing, profiles
+	// and label overrides (PLARIX_SHOW_CONFIG).
+	ShowConfig bool
+	// Suggestions adds cheaper same-provider alternatives to the After
+	// models (PLARIX_SUGGESTIONS).
+	Suggestions bool
+}
+
+func buildReport(in reportInput) string {
+	var b strings.Builder
+	// The marker must stay first so upsertComment can find the comment again.
+	fmt.Fprintf(&b, "%s\n\n", commentMarker)
+	fmt.Fprintf(&b, "## %s\n\n", safeValue(in.Title, defaultTitle))
+
+	if len(in.WatchedAdded) > 0 {
+		fmt.Fprintf(&b, "> 🚨 **Watched model introduced:** %s\n\n", strings.Join(in.WatchedAdded, ", "))
+	}
+
+	// Determine data source mode
+	hasMeasured := in.BaseMeasured != nil || in.HeadMeasured != nil
+	hasConfig := in.ConfigFound
+	hasSignals := hasAnySignals(in.Signals)
+
+	// Definitions
+	fmt.Fprintf(&b, "**Definitions:**\n")
+	if in.BaseRef != "" {
+		fmt.Fprintf(&b, "- **Before** = `%s` (`PLARIX_BASE_REF`)\n", in.BaseRef)
+	} else {
+		fmt.Fprintf(&b, "- **Before** = base branch (merge target)\n")
+	}
+	fmt.Fprintf(&b, "- **After** = PR head (this PR's code)\n\n")
+
+	fmt.Fprintf(&b, "**Data source:** `%s`\n\n", dataSourceOf(in))
+	if in.Truncated {
+		fmt.Fprintf(&b, "_⚠️ Only the first %d changed files were scanned (`PLARIX_MAX_FILES`); signals in later files are missing._\n\n", in.FilesChanged)
+	}
+
+	// Pricing info
+	fmt.Fprintf(&b, "_Pricing: %s · Sources: %s_\n\n", safeValue(in.Pricing.LastUpdated, "unknown"), strings.Join(in.Pricing.Sources, ", "))
+	writePricingAge(&b, in.Pricing)
+
+	switch {
+	case hasMeasured:
+		// MEASURED MODE - the "wow" feature
+		buildMeasuredReport(&b, in)
+	case hasConfig:
+		// CONFIGURED ESTIMATE MODE
+		buildConfiguredEstimateReport(&b, in, hasSignals)
+	default:
+		// HEURISTIC ONLY MODE - no config, no measured data
+		buildHeuristicOnlyReport(&b, in, hasSignals)
+	}
+
+	if in.Suggestions {
+		writeSuggestions(&b, in)
+	}
+	if in.ShowPricing {
+		writePricingUsed(&b, in)
+	}
+	if in.ShowConfig {
+		writeEffectiveConfig(&b, in)
+	}
+
+	if in.Budget != nil {
+		writeBudget(&b, *in.Budget)
+	}
+	if in.OrgBudget != nil {
+		writeOrgBudget(&b, *in.OrgBudget)
+	}
+
+	if footer := strings.TrimSpace(in.Footer); footer != "" {
+		fmt.Fprintf(&b, "\n---\n\n%s\n", footer)
+	}
+	return b.String()
+}
+
+func buildMeasuredReport(b *strings.Builder, in reportInput) {
+	fmt.Fprintf(b, "### ✅ Measured Token Usage (from CI test runs)\n\n")
+	if small := lowSample(in); small != "" {
+		fmt.Fprintf(b, "> ⚠️ **Low sample size — treat with caution:** %s, below the %d-call minimum (`PLARIX_MEASURE_MIN_CALLS`). A smoke-test run may not represent production traffic.\n\n", small, in.MinCalls)
+	}
+
+	if in.BaseMeasured != nil && in.HeadMeasured != nil {
+		// Before/After comparison
+		fmt.Fprintf(b, "| | Calls | Input Tokens | Output Tokens | Total Cost |\n")
+		fmt.Fprintf(b, "|---|---:|---:|---:|---:|\n")
+		fmt.Fprintf(b, "| Before | %d | %s | %s | %s |\n",
+			in.BaseMeasured.CallCount,
+			formatInt(in.BaseMeasured.TotalInputTokens),
+			formatInt(in.BaseMeasured.TotalOutputTokens),
+			formatCost(in.BaseMeasured.TotalCost))
+		fmt.Fprintf(b, "| After | %d | %s | %s | %s |\n\n",
+			in.HeadMeasured.CallCount,
+			formatInt(in.HeadMeasured.TotalInputTokens),
+			formatInt(in.HeadMeasured.TotalOutputTokens),
+			formatCost(in.HeadMeasured.TotalCost))
+
+		writeDelta(b, in.BaseMeasured.TotalCost, in.HeadMeasured.TotalCost)
+		writeSignificance(b, in.BaseMeasured, in.HeadMeasured)
+		writeEfficiency(b, in.BaseMeasured, in.HeadMeasured)
+
+		// Trend bar
+		maxCost := in.BaseMeasured.TotalCost
+		if in.HeadMeasured.TotalCost > maxCost {
+			maxCost = in.HeadMeasured.TotalCost
+		}
+		if maxCost == 0 {
+			maxCost = 1
+		}
+		fmt.Fprintf(b, "```\n")
+		fmt.Fprintf(b, "Before |%s %s\n", bar(in.BaseMeasured.TotalCost, maxCost), formatCost(in.BaseMeasured.TotalCost))
+		fmt.Fprintf(b, "After  |%s %s\n", bar(in.HeadMeasured.TotalCost, maxCost), formatCost(in.HeadMeasured.TotalCost))
+		fmt.Fprintf(b, "```\n\n")
 
 		// Models used
 		allModels := mergeModelCounts(in.BaseMeasured.Models, in.HeadMeasured.Models)
 		if len(allModels) > 0 {
 			fmt.Fprintf(b, "**Models used:** %s\n\n", strings.Join(allModels, ", "))
 		}
-	} else if in.HeadMeasured != nil {
-		// Only head measured
-		fmt.Fprintf(b, "| Calls | Input Tokens | Output Tokens | Total Cost |\n")
-		fmt.Fprintf(b, "|---:|---:|---:|---:|\n")
-		fmt.Fprintf(b, "| %d | %s | %s | $%.4f |\n\n",
-			in.HeadMeasured.CallCount,
-			formatInt(in.HeadMeasured.TotalInputTokens),
-			formatInt(in.HeadMeasured.TotalOutputTokens),
-			in.HeadMeasured.TotalCost)
+		added, removed := modelSetChanges(in.BaseMeasured.Models, in.HeadMeasured.Models)
+		if len(added) > 0 {
+			fmt.Fprintf(b, "**Added models:** %s\n\n", strings.Join(added, ", "))
+		}
+		if len(removed) > 0 {
+			fmt.Fprintf(b, "**Removed models:** %s\n\n", strings.Join(removed, ", "))
+		}
+	} else if in.HeadMeasured != nil {
+		// Only head measured
+		fmt.Fprintf(b, "| Calls | Input Tokens | Output Tokens | Total Cost |\n")
+		fmt.Fprintf(b, "|---:|---:|---:|---:|\n")
+		fmt.Fprintf(b, "| %d | %s | %s | %s |\n\n",
+			in.HeadMeasured.CallCount,
+			formatInt(in.HeadMeasured.TotalInputTokens),
+			formatInt(in.HeadMeasured.TotalOutputTokens),
+			formatCost(in.HeadMeasured.TotalCost))
+
+		if len(in.HeadMeasured.Models) > 0 {
+			models := make([]string, 0, len(in.HeadMeasured.Models))
+			for m := range in.HeadMeasured.Models {
+				models = append(models, m)
+			}
+			fmt.Fprintf(b, "**Models used:** %s\n\n", strings.Join(models, ", "))
+		}
+		fmt.Fprintf(b, "_Note: Only HEAD measurement available. Set `PLARIX_MEASURE_BASE` to enable before/after comparison._\n\n")
+	} else if in.BaseMeasured != nil {
+		// Only base measured
+		fmt.Fprintf(b, "| Calls | Input Tokens | Output Tokens | Total Cost |\n")
+		fmt.Fprintf(b, "|---:|---:|---:|---:|\n")
+		fmt.Fprintf(b, "| %d | %s | %s | %s |\n\n",
+			in.BaseMeasured.CallCount,
+			formatInt(in.BaseMeasured.TotalInputTokens),
+			formatInt(in.BaseMeasured.TotalOutputTokens),
+			formatCost(in.BaseMeasured.TotalCost))
+
+		fmt.Fprintf(b, "_Note: Only BASE measurement available. Set `PLARIX_MEASURE_HEAD` to enable before/after comparison._\n\n")
+	}
+
+	breakdown := writeModelBreakdown
+	if in.GroupByFamily {
+		breakdown = func(b *strings.Builder, label string, m *MeasuredSummary) {
+			writeFamilyBreakdown(b, label, m, in.Families)
+		}
+	}
+	if in.HeadMeasured != nil {
+		breakdown(b, "After", in.HeadMeasured)
+		writeLabelBreakdown(b, "After", in.HeadMeasured)
+		writeMaxCall(b, in.HeadMeasured.MaxCall)
+	} else {
+		breakdown(b, "Before", in.BaseMeasured)
+		writeLabelBreakdown(b, "Before", in.BaseMeasured)
+		writeMaxCall(b, in.BaseMeasured.MaxCall)
+	}
+	if in.HeadMeasured != nil {
+		writeCacheHitRate(b, in.HeadMeasured)
+	} else {
+		writeCacheHitRate(b, in.BaseMeasured)
+	}
+	writeSystemShare(b, in.BaseMeasured, in.HeadMeasured)
+	if in.ShowEnergy {
+		writeEnergy(b, in.BaseMeasured, in.HeadMeasured)
+	}
+	writeLogLineCounts(b, in.BaseMeasured, in.HeadMeasured)
+	if in.HeadMeasured != nil {
+		writeFreeTier(b, in.HeadMeasured)
+	} else {
+		writeFreeTier(b, in.BaseMeasured)
+	}
+
+	if in.ConfigFound {
+		measured := in.HeadMeasured
+		if measured == nil {
+			measured = in.BaseMeasured
+		}
+		writeConfigDrift(b, in.Config, in.Pricing, measured)
+		writeReconciliation(b, in.Config, in.Pricing, measured)
+	}
+
+	// Also show diff signals if any
+	if hasAnySignals(in.Signals) {
+		fmt.Fprintf(b, "---\n\n")
+		writeDiffSignals(b, in)
+	}
+}
+
+type pushInput struct {
+	SHA         string
+	Ref         string
+	ConfigFound bool
+	Config      Assumptions
+	Pricing     PricingFile
+	Measured    *MeasuredSummary
+	Title       string
+	Footer      string
+}
+
+// buildPushReport renders the cost of a single commit. It also returns a
+// one-line description for a commit status, empty when there is no cost.
+func buildPushReport(in pushInput) (string, string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s\n\n", safeValue(in.Title, defaultTitle))
+	fmt.Fprintf(&b, "**Commit:** `%s` on `%s`\n\n", shortSHA(in.SHA), safeValue(in.Ref, "unknown ref"))
+
+	var description string
+	switch {
+	case in.Measured != nil:
+		fmt.Fprintf(&b, "**Data source:** `%s`\n\n", DataSourceMeasured)
+		fmt.Fprintf(&b, "| Calls | Input Tokens | Output Tokens | Total Cost |\n")
+		fmt.Fprintf(&b, "|---:|---:|---:|---:|\n")
+		fmt.Fprintf(&b, "| %d | %s | %s | %s |\n\n",
+			in.Measured.CallCount,
+			formatInt(in.Measured.TotalInputTokens),
+			formatInt(in.Measured.TotalOutputTokens),
+			formatCost(in.Measured.TotalCost))
+		writeLogLineCounts(&b, nil, in.Measured)
+		description = fmt.Sprintf("Measured: %s over %d calls", formatCost(in.Measured.TotalCost), in.Measured.CallCount)
+	case in.ConfigFound:
+		cost, found := computeEstimate(in.Config, in.Pricing, in.Config.Model)
+		fmt.Fprintf(&b, "**Data source:** `%s`\n\n", DataSourceConfiguredEstimate)
+		fmt.Fprintf(&b, "| Model | Est. per request | Est. monthly |\n")
+		fmt.Fprintf(&b, "|---|---:|---:|\n")
+		fmt.Fprintf(&b, "| %s | %s | %s |\n\n", in.Config.Model, formatCost(cost.PerRequest), formatCost(cost.Monthly))
+		if !found {
+			fmt.Fprintf(&b, "_⚠️ Pricing not found for %s; costs may be $0.00._\n\n", in.Config.Model)
+		}
+		fmt.Fprintf(&b, "_⚠️ These are **estimates** based on configured assumptions, not actual usage._\n\n")
+		description = fmt.Sprintf("Est. monthly: %s (%s)", formatCost(cost.Monthly), in.Config.Model)
+	default:
+		fmt.Fprintf(&b, "**Data source:** `%s`\n\n", DataSourceHeuristicOnly)
+		fmt.Fprintf(&b, "No `.plarix.yml` config and no `PLARIX_MEASURE_HEAD` log found, so there is no cost to track for this commit.\n\n")
+	}
+
+	fmt.Fprintf(&b, "_Pricing: %s · Sources: %s_\n", safeValue(in.Pricing.LastUpdated, "unknown"), strings.Join(in.Pricing.Sources, ", "))
+	if _, stale := stalePricing(in.Pricing, time.Now()); stale {
+		fmt.Fprintf(&b, "\n")
+		writePricingAge(&b, in.Pricing)
+	}
+	if footer := strings.TrimSpace(in.Footer); footer != "" {
+		fmt.Fprintf(&b, "\n---\n\n%s\n", footer)
+	}
+	return b.String(), description
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return safeValue(sha, "unknown")
+}
+
+// writeFreeTier notes how much of a measured log free_tokens allowances covered.
+func writeFreeTier(b *strings.Builder, m *MeasuredSummary) {
+	if m == nil || m.FreeTokensUsed == 0 {
+		return
+	}
+	if m.TotalCost == 0 {
+		fmt.Fprintf(b, "_✅ Within free tier: all %s tokens are covered by the configured allowance._\n\n", formatInt(m.FreeTokensUsed))
+		return
+	}
+	fmt.Fprintf(b, "_ℹ️ %s tokens were covered by the configured free-token allowance before list rates applied._\n\n", formatInt(m.FreeTokensUsed))
+}
+
+// targetCacheHitRate is the hit rate used for the savings projection.
+const targetCacheHitRate = 0.8
+
+// writeCacheHitRate shows the share of input served from cache and, below
+// the target rate, what reaching it would save. Logs without cached token
+// counts are skipped.
+func writeCacheHitRate(b *strings.Builder, m *MeasuredSummary) {
+	if m == nil || m.TotalCachedInputTokens == 0 || m.TotalInputTokens == 0 {
+		return
+	}
+	rate := float64(m.TotalCachedInputTokens) / float64(m.TotalInputTokens)
+	fmt.Fprintf(b, "**Cache hit rate:** %.1f%% (%s of %s input tokens)\n\n",
+		rate*100, formatInt(m.TotalCachedInputTokens), formatInt(m.TotalInputTokens))
+	if rate >= targetCacheHitRate {
+		return
+	}
+	// Savings scale linearly with the cached share of input.
+	if extra := targetCacheHitRate*m.MaxCacheSavings - m.CacheSavings; extra > 0 {
+		fmt.Fprintf(b, "_At %.0f%% hit rate you'd save about %s on this run (projection; assumes the same traffic and cache pricing)._\n\n",
+			targetCacheHitRate*100, formatCost(extra))
+	}
+}
+
+// writeConfigDrift warns when the measured calls never use the configured
+// provider or model, meaning .plarix.yml no longer describes the code. Only
+// names the file sets are checked; defaults describe nothing.
+func writeConfigDrift(b *strings.Builder, a Assumptions, pricing PricingFile, m *MeasuredSummary) {
+	if m == nil || m.CallCount == 0 {
+		return
+	}
+	if provider := configProvider(a, pricing); provider != "" && (a.ProviderSet || a.ModelSet) && len(m.Providers) > 0 {
+		if _, ok := m.Providers[provider]; !ok {
+			fmt.Fprintf(b, "_⚠️ Config drift: `.plarix.yml` sets provider `%s` but measured calls use %s._\n\n", provider, strings.Join(sortedKeys(m.Providers), ", "))
+		}
+	}
+	if !a.ModelSet {
+		return
+	}
+	// Compare names rather than price rows: unpriced models share an empty
+	// row and would otherwise always match.
+	_, want := splitModelID("", a.Model)
+	for key := range m.Models {
+		_, model := splitMeasuredKey(key)
+		if _, got := splitModelID("", model); strings.EqualFold(got, want) {
+			return
+		}
+	}
+	fmt.Fprintf(b, "_⚠️ Config drift: `.plarix.yml` sets model `%s` but measured calls use %s._\n\n", a.Model, strings.Join(sortedKeys(m.Models), ", "))
+}
+
+// writeReconciliation compares the .plarix.yml assumptions with the measured
+// per-call averages so teams can see how far off their estimates are.
+func writeReconciliation(b *strings.Builder, a Assumptions, pricing PricingFile, m *MeasuredSummary) {
+	if m == nil || m.CallCount == 0 {
+		return
+	}
+	est, _ := computeEstimate(a, pricing, a.Model)
+	calls := float64(m.CallCount)
+	avgIn := float64(m.TotalInputTokens) / calls
+	avgOut := float64(m.TotalOutputTokens) / calls
+	avgCost := m.TotalCost / calls
+
+	fmt.Fprintf(b, "### 🔁 Estimate vs Measured\n\n")
+	fmt.Fprintf(b, "| | Configured | Measured avg | Ratio |\n")
+	fmt.Fprintf(b, "|---|---:|---:|---:|\n")
+	fmt.Fprintf(b, "| Input tokens/request | %s | %s | %s |\n", formatInt(a.AvgInputTokens), formatInt(int(math.Round(avgIn))), ratioOf(avgIn, float64(a.AvgInputTokens)))
+	fmt.Fprintf(b, "| Output tokens/request | %s | %s | %s |\n", formatInt(a.AvgOutputTokens), formatInt(int(math.Round(avgOut))), ratioOf(avgOut, float64(a.AvgOutputTokens)))
+	fmt.Fprintf(b, "| Cost/request | %s | %s | %s |\n\n", formatCost(est.PerRequest), formatCost(avgCost), ratioOf(avgCost, est.PerRequest))
+	fmt.Fprintf(b, "_Configured figures use %s; a ratio far from 1.0x means `.plarix.yml` assumptions need updating._\n\n", a.Model)
+}
+
+// ratioOf renders actual/expected as a multiplier, or "—" when undefined.
+func ratioOf(actual, expected float64) string {
+	if expected == 0 {
+		return "—"
+	}
+	return fmt.Sprintf("%.2fx", actual/expected)
+}
+
+// measuredKey identifies a measured model as "provider/model" so same-named
+// models of different providers are counted and priced apart. Calls logged
+// without a provider use the bare model name.
+func measuredKey(provider, model string) string {
+	if provider == "" {
+		return model
+	}
+	return provider + "/" + model
+}
+
+// splitMeasuredKey reverses measuredKey. A bare OpenRouter-style ID such as
+// anthropic/claude-3.5-sonnet reads as that vendor's model, which prices the
+// same.
+func splitMeasuredKey(key string) (provider, model string) {
+	if p, m, ok := strings.Cut(key, "/"); ok && knownProviders[p] {
+		return p, m
+	}
+	return "", key
+}
+
+// writeModelBreakdown shows each model's share of total measured cost,
+// largest first, so the few models driving spend stand out.
+func writeModelBreakdown(b *strings.Builder, label string, m *MeasuredSummary) {
+	if m == nil || len(m.ModelCosts) < 2 || m.TotalCost == 0 {
+		return
+	}
+	models := make([]string, 0, len(m.ModelCosts))
+	for name := range m.ModelCosts {
+		models = append(models, name)
+	}
+	sort.Slice(models, func(i, j int) bool {
+		if m.ModelCosts[models[i]] != m.ModelCosts[models[j]] {
+			return m.ModelCosts[models[i]] > m.ModelCosts[models[j]]
+		}
+		return models[i] < models[j]
+	})
+
+	fmt.Fprintf(b, "**Cost by model (%s):**\n\n", label)
+	fmt.Fprintf(b, "| Provider | Model | Calls | Cost | Share | |\n")
+	fmt.Fprintf(b, "|---|---|---:|---:|---:|---|\n")
+	for _, key := range models {
+		cost := m.ModelCosts[key]
+		provider, name := splitMeasuredKey(key)
+		fmt.Fprintf(b, "| %s | %s | %d | %s | %.1f%% | `%s` |\n", safeValue(provider, "—"), name, m.Models[key], formatCost(cost), cost/m.TotalCost*100, bar(cost, m.TotalCost))
+	}
+	fmt.Fprintf(b, "\n")
+}
+
+// unlabeled groups measured calls logged without a label.
+const unlabeled = "unlabeled"
+
+// dataSourceOf is the report's data source label.
+func dataSourceOf(in reportInput) string {
+	switch {
+	case (in.BaseMeasured != nil || in.HeadMeasured != nil) && lowSample(in) != "":
+		return DataSourceMeasuredLowSample
+	case in.BaseMeasured != nil || in.HeadMeasured != nil:
+		return DataSourceMeasured
+	case in.ConfigFound:
+		return DataSourceConfiguredEstimate
+	}
+	return DataSourceHeuristicOnly
+}
+
+// lowSample describes the measured logs with fewer than in.MinCalls calls,
+// e.g. "After log has 3 calls", or returns "" when every log is big enough.
+func lowSample(in reportInput) string {
+	var small []string
+	for _, side := range []struct {
+		label string
+		m     *MeasuredSummary
+	}{{"Before", in.BaseMeasured}, {"After", in.HeadMeasured}} {
+		if side.m != nil && side.m.CallCount < in.MinCalls {
+			small = append(small, fmt.Sprintf("%s log has %d call(s)", side.label, side.m.CallCount))
+		}
+	}
+	return strings.Join(small, " and ")
+}
+
+// writeFamilyBreakdown is writeModelBreakdown with calls and cost summed
+// per model family (see familyFor), listing the SKUs seen in each.
+func writeFamilyBreakdown(b *strings.Builder, label string, m *MeasuredSummary, families map[string]string) {
+	if m == nil || len(m.ModelCosts) < 2 || m.TotalCost == 0 {
+		return
+	}
+	calls := make(map[string]int)
+	costs := make(map[string]float64)
+	members := make(map[string][]string)
+	for _, key := range sortedKeys(m.ModelCosts) {
+		_, name := splitMeasuredKey(key)
+		family := familyFor(name, families)
+		calls[family] += m.Models[key]
+		costs[family] += m.ModelCosts[key]
+		members[family] = append(members[family], name)
+	}
+	names := sortedKeys(costs)
+	sort.SliceStable(names, func(i, j int) bool { return costs[names[i]] > costs[names[j]] })
+
+	fmt.Fprintf(b, "**Cost by model family (%s):**\n\n", label)
+	fmt.Fprintf(b, "| Family | Models | Calls | Cost | Share | |\n")
+	fmt.Fprintf(b, "|---|---|---:|---:|---:|---|\n")
+	for _, family := range names {
+		cost := costs[family]
+		fmt.Fprintf(b, "| %s | %s | %d | %s | %.1f%% | `%s` |\n", family, strings.Join(uniqueStrings(members[family]), ", "),
+			calls[family], formatCost(cost), cost/m.TotalCost*100, bar(cost, m.TotalCost))
+	}
+	fmt.Fprintf(b, "\n")
+}
+
+// writeLabelBreakdown shows measured cost per log label (feature, endpoint),
+// largest first. It is omitted when no call carries a label.
+func writeLabelBreakdown(b *strings.Builder, side string, m *MeasuredSummary) {
+	if m == nil || len(m.Labels) == 0 || (len(m.Labels) == 1 && m.Labels[unlabeled] > 0) {
+		return
+	}
+	labels := sortedKeys(m.LabelCosts)
+	sort.SliceStable(labels, func(i, j int) bool { return m.LabelCosts[labels[i]] > m.LabelCosts[labels[j]] })
+
+	fmt.Fprintf(b, "**Cost by label (%s):**\n\n", side)
+	fmt.Fprintf(b, "| Label | Calls | Cost | Share |\n")
+	fmt.Fprintf(b, "|---|---:|---:|---:|\n")
+	for _, label := range labels {
+		cost := m.LabelCosts[label]
+		share := 0.0
+		if m.TotalCost > 0 {
+			share = cost / m.TotalCost * 100
+		}
+		fmt.Fprintf(b, "| %s | %d | %s | %.1f%% |\n", label, m.Labels[label], formatCost(cost), share)
+	}
+	fmt.Fprintf(b, "\n")
+}
+
+// writeSystemShare shows how much of the input is system prompt, the fixed
+// per-call overhead, for logs with per-role token counts. Logs with only
+// input_tokens show nothing.
+func writeSystemShare(b *strings.Builder, base, head *MeasuredSummary) {
+	var sides []string
+	var detail *MeasuredSummary
+	for _, side := range []struct {
+		label string
+		m     *MeasuredSummary
+	}{{"Before", base}, {"After", head}} {
+		if side.m == nil || side.m.RoleCalls == 0 {
+			continue
+		}
+		total := side.m.SystemTokens + side.m.UserTokens + side.m.AssistantTokens
+		sides = append(sides, fmt.Sprintf("%s %.1f%%", side.label, float64(side.m.SystemTokens)/float64(total)*100))
+		detail = side.m
+	}
+	if detail == nil {
+		return
+	}
+	fmt.Fprintf(b, "**System prompt share of input:** %s\n\n", strings.Join(sides, " → "))
+	fmt.Fprintf(b, "_System %s / user %s / assistant %s tokens across %d of %d calls with a role breakdown._\n\n",
+		formatInt(detail.SystemTokens), formatInt(detail.UserTokens), formatInt(detail.AssistantTokens), detail.RoleCalls, detail.CallCount)
+}
+
+// gridKgCO2ePerKWh is the carbon intensity used to turn energy into CO2e, a
+// rounded global grid average.
+const gridKgCO2ePerKWh = 0.4
+
+// writeEnergy shows the measured calls' approximate energy and emissions,
+// Before → After when both logs exist.
+func writeEnergy(b *strings.Builder, base, head *MeasuredSummary) {
+	var sides []string
+	unknown := 0
+	for _, side := range []struct {
+		label string
+		m     *MeasuredSummary
+	}{{"Before", base}, {"After", head}} {
+		if side.m == nil {
+			continue
+		}
+		kWh := side.m.EnergyWh / 1000
+		sides = append(sides, fmt.Sprintf("%s ~%s kWh / ~%s g CO2e", side.label,
+			strconv.FormatFloat(kWh, 'g', 2, 64), strconv.FormatFloat(kWh*gridKgCO2ePerKWh*1000, 'g', 2, 64)))
+		unknown += side.m.EnergyUnknownCalls
+	}
+	if len(sides) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "**Energy (rough estimate):** %s\n\n", strings.Join(sides, " → "))
+	note := fmt.Sprintf("Order-of-magnitude figures from per-model energy coefficients at %g kg CO2e/kWh; not provider-reported.", gridKgCO2ePerKWh)
+	if unknown > 0 {
+		note += fmt.Sprintf(" %d call(s) have no coefficient and are excluded.", unknown)
+	}
+	fmt.Fprintf(b, "_%s_\n\n", note)
+}
+
+// growthHorizons are the months shown by the growth projection; 0 is today.
+var growthHorizons = []int{0, 3, 12}
+
+// writeGrowthProjection prices both sides with requests/day compounded by
+// the After config's monthly_growth_percent, so a change that is cheap at
+// today's volume shows what it costs at scale. Each column re-runs the
+// estimate, so free-token allowances run out where they would.
+func writeGrowthProjection(b *strings.Builder, in reportInput) {
+	growth := in.Config.MonthlyGrowth
+	if growth == 0 {
+		return
+	}
+	project := func(a Assumptions, estimate func(reportInput, Assumptions) (costPair, bool)) []float64 {
+		out := make([]float64, len(growthHorizons))
+		base := a.RequestsPerDay
+		for i, months := range growthHorizons {
+			a.RequestsPerDay = base * math.Pow(1+growth, float64(months))
+			cost, _ := estimate(in, a)
+			out[i] = cost.Monthly
+		}
+		return out
+	}
+	before := project(beforeAssumptions(in), beforeEstimate)
+	after := project(afterAssumptions(in), afterEstimate)
+
+	fmt.Fprintf(b, "**Growth projection** (requests/day %s%% per month, compounding; est. monthly cost):\n\n",
+		strconv.FormatFloat(growth*100, 'f', -1, 64))
+	header, align := "| |", "|---|"
+	rowBefore, rowAfter, rowDelta := "| Before |", "| After |", "| Δ |"
+	for i, months := range growthHorizons {
+		if months == 0 {
+			header += " Now |"
+		} else {
+			header += fmt.Sprintf(" Month %d |", months)
+		}
+		align += "---:|"
+		rowBefore += " " + formatCost(before[i]) + " |"
+		rowAfter += " " + formatCost(after[i]) + " |"
+		rowDelta += " " + signedCost(after[i]-before[i]) + " |"
+	}
+	fmt.Fprintf(b, "%s\n%s\n%s\n%s\n%s\n\n", header, align, rowBefore, rowAfter, rowDelta)
+}
+
+// writeMaxCall points at the single priciest call, which often reveals a
+// runaway prompt in tests.
+func writeMaxCall(b *strings.Builder, c *MeasuredCall) {
+	if c == nil || c.Cost == 0 {
+		return
+	}
+	fmt.Fprintf(b, "**Most expensive call:** %s (%s, %s input / %s output tokens)\n\n",
+		formatCost(c.Cost), c.Model, formatInt(c.InputTokens), formatInt(c.OutputTokens))
+}
+
+func writeLogLineCounts(b *strings.Builder, base, head *MeasuredSummary) {
+	var parts []string
+	if base != nil {
+		parts = append(parts, fmt.Sprintf("Before %d parsed / %d skipped", base.CallCount, base.SkippedLines))
+	}
+	if head != nil {
+		parts = append(parts, fmt.Sprintf("After %d parsed / %d skipped", head.CallCount, head.SkippedLines))
+	}
+	fmt.Fprintf(b, "_Log lines: %s_\n\n", strings.Join(parts, " · "))
+}
+
+func buildConfiguredEstimateReport(b *strings.Builder, in reportInput, hasSignals bool) {
+	fmt.Fprintf(b, "### 📋 Configured Estimate (from .plarix.yml)\n\n")
+
+	beforeCfg := baseAssumptions(in)
+
+	// Show assumptions explicitly
+	if beforeCfg != in.Config {
+		fmt.Fprintf(b, "**Assumptions from config** (changed in this PR, Before → After):\n")
+		fmt.Fprintf(b, "- Requests/day: %s\n", changeOf(formatVolume(beforeCfg.RequestsPerDay), formatVolume(in.Config.RequestsPerDay)))
+		fmt.Fprintf(b, "- Avg input tokens: %s\n", changeOf(strconv.Itoa(beforeCfg.AvgInputTokens), strconv.Itoa(in.Config.AvgInputTokens)))
+		fmt.Fprintf(b, "- Avg output tokens: %s\n", changeOf(strconv.Itoa(beforeCfg.AvgOutputTokens), strconv.Itoa(in.Config.AvgOutputTokens)))
+		fmt.Fprintf(b, "- Provider: %s\n", changeOf(providerLabel(beforeCfg.Provider), providerLabel(in.Config.Provider)))
+		fmt.Fprintf(b, "- Model: %s\n", changeOf(beforeCfg.Model, in.Config.Model))
+		if beforeCfg.CacheHitRate > 0 || in.Config.CacheHitRate > 0 {
+			fmt.Fprintf(b, "- Cache hit rate: %s\n", changeOf(hitRateLabel(beforeCfg.CacheHitRate), hitRateLabel(in.Config.CacheHitRate)))
+		}
+		if in.Config.Profile != "" {
+			fmt.Fprintf(b, "- Profile: %s\n", in.Config.Profile)
+		}
+		fmt.Fprintf(b, "\n")
+	} else {
+		fmt.Fprintf(b, "**Assumptions from config:**\n")
+		fmt.Fprintf(b, "- Requests/day: %s\n", formatVolume(in.Config.RequestsPerDay))
+		fmt.Fprintf(b, "- Avg input tokens: %d\n", in.Config.AvgInputTokens)
+		fmt.Fprintf(b, "- Avg output tokens: %d\n", in.Config.AvgOutputTokens)
+		fmt.Fprintf(b, "- Provider: %s\n", providerLabel(in.Config.Provider))
+		fmt.Fprintf(b, "- Model: %s\n", in.Config.Model)
+		if in.Config.CacheHitRate > 0 {
+			fmt.Fprintf(b, "- Cache hit rate: %s\n", hitRateLabel(in.Config.CacheHitRate))
+		}
+		if in.Config.Profile != "" {
+			fmt.Fprintf(b, "- Profile: %s\n", in.Config.Profile)
+		}
+		fmt.Fprintf(b, "\n")
+	}
+
+	beforeModel := beforeModelFor(in)
+	afterModel := afterModelFor(in)
+	if in.BaseRef == "" && len(in.Signals.BeforeWeights) > 0 {
+		beforeModel = weightsLabel(in.Signals.BeforeWeights)
+	}
+	if len(in.Signals.AfterWeights) > 0 {
+		afterModel = weightsLabel(in.Signals.AfterWeights)
+	}
+
+	beforeCost, beforeFound := beforeEstimate(in, beforeAssumptions(in))
+	afterCost, afterFound := afterEstimate(in, afterAssumptions(in))
+
+	// Show formula
+	if fee := afterAssumptions(in).PerCallFee; fee > 0 {
+		fmt.Fprintf(b, "**Formula:** `cost = ((input_tokens × input_price + output_tokens × output_price) / 1M + per_call_fee) × requests/day × 30` (per_call_fee %s)\n\n", formatCost(fee))
+	} else {
+		fmt.Fprintf(b, "**Formula:** `cost = (input_tokens × input_price + output_tokens × output_price) / 1M × requests/day × 30`\n\n")
+	}
+	if beforeAssumptions(in).CacheHitRate > 0 || afterAssumptions(in).CacheHitRate > 0 {
+		fmt.Fprintf(b, "_`input_price` blends in the cached input rate for the assumed cache hit share; models without a cached rate bill all input at the full rate._\n\n")
+	}
+
+	// Cost table
+	fmt.Fprintf(b, "| | Model | Est. per request | Est. monthly |\n")
+	fmt.Fprintf(b, "|---|---|---:|---:|\n")
+	fmt.Fprintf(b, "| Before | %s | %s | %s |\n", beforeModel, formatCost(beforeCost.PerRequest), formatCost(beforeCost.Monthly))
+	fmt.Fprintf(b, "| After | %s | %s | %s |\n\n", afterModel, formatCost(afterCost.PerRequest), formatCost(afterCost.Monthly))
+	writeDelta(b, beforeCost.Monthly, afterCost.Monthly)
+	if p := in.Config.RolloutPercent; p > 0 && p < 100 {
+		// Only the rolled-out share of traffic moves from Before to After.
+		rolled := beforeCost.Monthly + (afterCost.Monthly-beforeCost.Monthly)*p/100
+		fmt.Fprintf(b, "**At %s%% rollout:** After ≈ %s/month near term (%s vs Before); %s/month at full rollout.\n\n",
+			strconv.FormatFloat(p, 'f', -1, 64), formatCost(rolled), signedCost(rolled-beforeCost.Monthly), formatCost(afterCost.Monthly))
+	}
+
+	// Trend bar
+	maxMonthly := beforeCost.Monthly
+	if afterCost.Monthly > maxMonthly {
+		maxMonthly = afterCost.Monthly
+	}
+	if maxMonthly == 0 {
+		maxMonthly = 1
+	}
+	fmt.Fprintf(b, "```\n")
+	fmt.Fprintf(b, "Before |%s %s\n", bar(beforeCost.Monthly, maxMonthly), formatCost(beforeCost.Monthly))
+	fmt.Fprintf(b, "After  |%s %s\n", bar(afterCost.Monthly, maxMonthly), formatCost(afterCost.Monthly))
+	fmt.Fprintf(b, "```\n\n")
+
+	if !beforeFound || !afterFound {
+		fmt.Fprintf(b, "_⚠️ Pricing not found for one or more models; costs may be $0.00._\n\n")
+	}
+
+	if in.Config.UnitDivisor > 0 {
+		fmt.Fprintf(b, "**Cost per %s:** %s (%s requests per %s)\n\n", safeValue(in.Config.UnitName, "unit"),
+			changeOf(formatCost(beforeCost.PerUnit), formatCost(afterCost.PerUnit)),
+			strconv.FormatFloat(in.Config.UnitDivisor, 'f', -1, 64), safeValue(in.Config.UnitName, "unit"))
+	}
+
+	writeGrowthProjection(b, in)
+
+	switch {
+	case beforeCost.WithinFreeTier && afterCost.WithinFreeTier:
+		fmt.Fprintf(b, "_✅ Within free tier: the free-token allowance covers projected monthly usage on both sides._\n\n")
+	case afterCost.WithinFreeTier:
+		fmt.Fprintf(b, "_✅ After is within free tier: the %s allowance covers projected monthly usage._\n\n", afterModel)
+	case beforeCost.WithinFreeTier:
+		fmt.Fprintf(b, "_⚠️ After exceeds the free tier that covered Before's projected monthly usage._\n\n")
+	}
+
+	if beforeFound && afterFound && !strings.EqualFold(beforeModel, afterModel) && beforeCost == afterCost {
+		fmt.Fprintf(b, "_ℹ️ Model changed (%s → %s) but pricing is identical, so there is no cost impact._\n\n", beforeModel, afterModel)
+	}
+
+	if line, ok := retryImpact(in.Signals); ok {
+		fmt.Fprintf(b, "%s\n\n", line)
+	}
+	if line, ok := promptImpact(in); ok {
+		fmt.Fprintf(b, "%s\n\n", line)
+	}
+	if line, ok := concurrencyImpact(in); ok {
+		fmt.Fprintf(b, "%s\n\n", line)
+	}
+	if line, ok := contextImpact(in); ok {
+		fmt.Fprintf(b, "%s\n\n", line)
+	}
+
+	if ranked := rankAddedModels(in.Signals, in.Config, in.Pricing); len(ranked) > 1 {
+		fmt.Fprintf(b, "**Added models by est. cost per request** (at configured token sizes):\n")
+		for i, r := range ranked {
+			if !r.Found {
+				fmt.Fprintf(b, "%d. %s — ⚠️ no pricing data\n", i+1, r.Model)
+				continue
+			}
+			fmt.Fprintf(b, "%d. %s — %s/request, %s/month\n", i+1, r.Model, formatCost(r.Cost.PerRequest), formatCost(r.Cost.Monthly))
+		}
+		fmt.Fprintf(b, "\n")
+	}
+
+	fmt.Fprintf(b, "_⚠️ These are **estimates** based on configured assumptions, not actual usage._\n\n")
+
+	// Diff signals
+	if hasSignals {
+		fmt.Fprintf(b, "---\n\n")
+		writeDiffSignals(b, in)
+	}
+}
+
+func buildHeuristicOnlyReport(b *strings.Builder, in reportInput, hasSignals bool) {
+	fmt.Fprintf(b, "### ⚠️ Cannot Compute Real Cost\n\n")
+	fmt.Fprintf(b, "**No `.plarix.yml` config and no measured token logs found.**\n\n")
+	fmt.Fprintf(b, "Without configuration or measurements, we cannot estimate costs reliably.\n\n")
+	if in.FilesChanged > 0 {
+		fmt.Fprintf(b, "**Diff size:** %d file(s) changed, %d with LLM-relevant signals.\n\n", in.FilesChanged, in.SignalFiles)
+	}
+
+	if hasSignals {
+		fmt.Fprintf(b, "---\n\n")
+		fmt.Fprintf(b, "### 🔍 Detected PR Signals (diff-based heuristics)\n\n")
+		writeDiffSignals(b, in)
+
+		if len(in.Signals.BeforeModels) > 0 && len(in.Signals.AfterModels) > 0 {
+			before, after := in.Signals.BeforeModels[0], in.Signals.AfterModels[0]
+			bp, bFound := lookupPrice(in.Pricing, "", before)
+			ap, aFound := lookupPrice(in.Pricing, "", after)
+			if bFound && aFound && !strings.EqualFold(before, after) &&
+				bp.InputPerMillion == ap.InputPerMillion && bp.OutputPerMillion == ap.OutputPerMillion {
+				fmt.Fprintf(b, "_ℹ️ Model changed (%s → %s) but list pricing is identical._\n\n", before, after)
+			}
+		}
+
+		// Without assumptions there are no token sizes, so rank by list rates only.
+		if ranked := rankAddedModels(in.Signals, Assumptions{}, in.Pricing); len(ranked) > 1 {
+			fmt.Fprintf(b, "**Added models by list price** (per 1M tokens):\n")
+			for i, r := range ranked {
+				if !r.Found {
+					fmt.Fprintf(b, "%d. %s — ⚠️ no pricing data\n", i+1, r.Model)
+					continue
+				}
+				fmt.Fprintf(b, "%d. %s — %s in / %s out\n", i+1, r.Model, formatCost(r.Price.InputPerMillion), formatCost(r.Price.OutputPerMillion))
+			}
+			fmt.Fprintf(b, "\n")
+		}
+	} else {
+		fmt.Fprintf(b, "No LLM-cost-relevant changes detected in this PR.\n\n")
+	}
+
+	// How to enable; upsertComment collapses this after the first comment.
+	fmt.Fprintf(b, "---\n\n")
+	fmt.Fprintf(b, "%s\n", onboardingStart)
+	fmt.Fprintf(b, "### 📖 How to Enable Real Reporting\n\n")
+	fmt.Fprintf(b, "**Option 1: Configured Estimate** (quick setup)\n")
+	fmt.Fprintf(b, "Create `.plarix.yml` in your repo root:\n")
+	fmt.Fprintf(b, "```yaml\n")
+	fmt.Fprintf(b, "assumptions:\n")
+	fmt.Fprintf(b, "  requests_per_day: 10000\n")
+	fmt.Fprintf(b, "  avg_input_tokens: 800\n")
+	fmt.Fprintf(b, "  avg_output_tokens: 400\n")
+	fmt.Fprintf(b, "  provider: \"openai\"\n")
+	fmt.Fprintf(b, "  model: \"gpt-4o-mini\"\n")
+	fmt.Fprintf(b, "```\n\n")
+	fmt.Fprintf(b, "**Option 2: Measured Mode** (most accurate)\n")
+	fmt.Fprintf(b, "Instrument your tests to log token usage to JSONL files, then set:\n")
+	fmt.Fprintf(b, "- `PLARIX_MEASURE_BASE` = path to base branch usage log\n")
+	fmt.Fprintf(b, "- `PLARIX_MEASURE_HEAD` = path to PR head usage log\n\n")
+	fmt.Fprintf(b, "See [plarix-action README](https://github.com/aegix-ai/plarix-action) for detailed setup.\n")
+	fmt.Fprintf(b, "%s\n", onboardingEnd)
+}
+
+// The setup guide of heuristic-only reports sits between these markers so
+// later comments on the same PR can shorten it to a link.
+const (
+	onboardingStart = "<!-- plarix-onboarding -->"
+	onboardingEnd   = "<!-- /plarix-onboarding -->"
+)
+
+// collapseOnboarding replaces the setup guide in body with a one-line link,
+// keeping onboardingStart so later runs still recognize it as seen.
+func collapseOnboarding(body string) string {
+	start := strings.Index(body, onboardingStart)
+	end := strings.Index(body, onboardingEnd)
+	if start < 0 || end < start {
+		return body
+	}
+	link := onboardingStart + "\n_📖 Add `.plarix.yml` or measured usage logs for cost estimates — see the [setup guide](https://github.com/aegix-ai/plarix-action#quick-start)._"
+	return body[:start] + link + body[end+len(onboardingEnd):]
+}
+
+// writePricingUsed lists, in a collapsed block, the pricing rows behind every
+// model the report refers to so reviewers can check the rates.
+func writePricingUsed(b *strings.Builder, in reportInput) {
+	type ref struct{ provider, model string }
+	var refs []ref
+	if in.BaseMeasured != nil || in.HeadMeasured != nil {
+		for _, m := range []*MeasuredSummary{in.BaseMeasured, in.HeadMeasured} {
+			if m != nil {
+				for _, key := range sortedKeys(m.Models) {
+					provider, name := splitMeasuredKey(key)
+					refs = append(refs, ref{provider, name})
+				}
+			}
+		}
+	} else {
+		if in.BaseConfig != nil {
+			refs = append(refs, ref{in.BaseConfig.Provider, in.BaseConfig.Model})
+		}
+		refs = append(refs, ref{in.Config.Provider, in.Config.Model})
+		if in.ConfigFound {
+			refs = append(refs, ref{beforeAssumptions(in).Provider, beforeModelFor(in)}, ref{afterAssumptions(in).Provider, afterModelFor(in)})
+		}
+	}
+	for _, m := range append(append([]string{}, in.Signals.BeforeModels...), in.Signals.AfterModels...) {
+		refs = append(refs, ref{in.Config.Provider, m})
+	}
+
+	seen := map[string]bool{}
+	var rows, missing []string
+	for _, r := range refs {
+		price, found := lookupPrice(in.Pricing, r.provider, r.model)
+		if !found {
+			if !seen["?"+r.model] {
+				seen["?"+r.model] = true
+				missing = append(missing, r.model)
+			}
+			continue
+		}
+		key := price.Provider + "/" + price.Name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		cached := "—"
+		if price.CachedInputPerMillion > 0 {
+			cached = formatCost(price.CachedInputPerMillion)
+		}
+		rows = append(rows, fmt.Sprintf("| %s | %s | %s | %s | %s |", price.Provider, price.Name,
+			formatCost(price.InputPerMillion), formatCost(price.OutputPerMillion), cached))
+	}
+	if len(rows)+len(missing) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "<details>\n<summary>💲 Pricing used (updated %s)</summary>\n\n", safeValue(in.Pricing.LastUpdated, "unknown"))
+	if len(rows) > 0 {
+		fmt.Fprintf(b, "| Provider | Model | Input / 1M | Output / 1M | Cached input / 1M |\n")
+		fmt.Fprintf(b, "|---|---|---:|---:|---:|\n")
+		fmt.Fprintf(b, "%s\n\n", strings.Join(rows, "\n"))
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(b, "No pricing data for: %s\n\n", strings.Join(missing, ", "))
+	}
+	fmt.Fprintf(b, "</details>\n\n")
+}
+
+// writeSuggestions proposes, for each model the After side uses, the
+// cheapest model from the same provider and family (familyFor) when it costs
+// materially less, so a suggestion never drops a capability tier. Costs
+// are compared per call at the configured token sizes, or per 1M input plus
+// 1M output tokens without a config.
+func writeSuggestions(b *strings.Builder, in reportInput) {
+	type ref struct{ provider, model string }
+	var refs []ref
+	if in.HeadMeasured != nil {
+		for _, key := range sortedKeys(in.HeadMeasured.Models) {
+			provider, model := splitMeasuredKey(key)
+			refs = append(refs, ref{provider, model})
+		}
+	}
+	for _, m := range uniqueStrings(in.Signals.AfterModels) {
+		refs = append(refs, ref{in.Config.Provider, m})
+	}
+	if in.ConfigFound && in.HeadMeasured == nil {
+		refs = append(refs, ref{afterAssumptions(in).Provider, afterModelFor(in)})
+	}
+
+	callCost := func(p ModelPrice) float64 {
+		if in.ConfigFound {
+			return p.Cost(in.Config.AvgInputTokens, in.Config.AvgOutputTokens)
+		}
+		return p.InputPerMillion + p.OutputPerMillion
+	}
+	seen := map[string]bool{}
+	var lines []string
+	for _, r := range refs {
+		price, found := lookupPrice(in.Pricing, r.provider, r.model)
+		if !found || seen[price.Provider+"/"+price.Name] {
+			continue
+		}
+		seen[price.Provider+"/"+price.Name] = true
+		current := callCost(price)
+		family := familyFor(r.model, in.Families)
+		best, bestCost := ModelPrice{}, current
+		for _, m := range in.Pricing.Models {
+			if m.Name == "" || !strings.EqualFold(m.Provider, price.Provider) || familyFor(m.Name, in.Families) != family {
+				continue
+			}
+			if callCost(m) < bestCost {
+				best, bestCost = m, callCost(m)
+			}
+		}
+		if best.Name == "" || !isMaterial(current, bestCost) {
+			continue
+		}
+		line := fmt.Sprintf("- Consider `%s` instead of `%s` (%+.0f%% cost) if quality permits", best.Name, r.model, (bestCost-current)/current*100)
+		if in.ConfigFound && in.HeadMeasured == nil {
+			a := afterAssumptions(in)
+			a.Provider = price.Provider
+			now, _ := computeEstimate(a, in.Pricing, price.Name)
+			alt, _ := computeEstimate(a, in.Pricing, best.Name)
+			if saved := now.Monthly - alt.Monthly; saved > 0 {
+				line += fmt.Sprintf(", saving about %s/month at the configured volume", formatCost(saved))
+			}
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "**💡 Savings suggestions:**\n%s\n\n", strings.Join(lines, "\n"))
+	fmt.Fprintf(b, "_Suggestions compare list rates only; check output quality before switching._\n\n")
+}
+
+// assumptionValues lists a's fields under their .plarix.yml keys, in the
+// order the report shows them.
+func assumptionValues(a Assumptions) [][2]string {
+	num := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return [][2]string{
+		{"requests_per_day", num(a.RequestsPerDay)},
+		{"avg_input_tokens", strconv.Itoa(a.AvgInputTokens)},
+		{"avg_output_tokens", strconv.Itoa(a.AvgOutputTokens)},
+		{"provider", providerLabel(a.Provider)},
+		{"model", a.Model},
+		{"openrouter_multiplier", num(a.OpenRouterMultiplier)},
+		{"unit_divisor", num(a.UnitDivisor)},
+		{"unit_name", safeValue(a.UnitName, "—")},
+		{"per_call_fee", num(a.PerCallFee)},
+		{"monthly_growth_percent", num(a.MonthlyGrowth * 100)},
+		{"rollout_percent", num(a.RolloutPercent)},
+		{"cache_hit_percent", num(a.CacheHitRate * 100)},
+	}
+}
+
+// writeEffectiveConfig shows the assumptions the estimate actually used, so
+// a key that failed to parse stands out as still holding its default.
+func writeEffectiveConfig(b *strings.Builder, in reportInput) {
+	fmt.Fprintf(b, "<details>\n<summary>⚙️ Effective config</summary>\n\n")
+	if !in.ConfigFound {
+		fmt.Fprintf(b, "No `%s` found; no assumptions were applied.\n\n</details>\n\n", configPath)
+		return
+	}
+	defaults := assumptionValues(defaultConfig().Assumptions)
+	after := assumptionValues(in.Config)
+	var before [][2]string
+	if in.BaseConfig != nil {
+		before = assumptionValues(*in.BaseConfig)
+		fmt.Fprintf(b, "| Key | Before | After |\n|---|---|---|\n")
+	} else {
+		fmt.Fprintf(b, "| Key | Value |\n|---|---|\n")
+	}
+	cell := func(i int, v string) string {
+		if v == defaults[i][1] {
+			return v + " _(default)_"
+		}
+		return v
+	}
+	for i, kv := range after {
+		if before != nil {
+			fmt.Fprintf(b, "| `%s` | %s | %s |\n", kv[0], cell(i, before[i][1]), cell(i, kv[1]))
+		} else {
+			fmt.Fprintf(b, "| `%s` | %s |\n", kv[0], cell(i, kv[1]))
+		}
+	}
+	fmt.Fprintf(b, "\n")
+	if in.Config.Profile != "" {
+		fmt.Fprintf(b, "Profile: `%s` (`PLARIX_PROFILE`)\n\n", in.Config.Profile)
+	}
+	fmt.Fprintf(b, "_Values marked default were not set in `%s`, or were invalid and ignored (see the job log). A `rollout:N` label overrides `rollout_percent`._\n\n", configPath)
+	fmt.Fprintf(b, "</details>\n\n")
+}
+
+// modelSetChanges lists models called only in head (added) or only in base
+// (removed), sorted.
+func modelSetChanges(base, head map[string]int) (added, removed []string) {
+	for _, m := range sortedKeys(head) {
+		if _, ok := base[m]; !ok {
+			added = append(added, m)
+		}
+	}
+	for _, m := range sortedKeys(base) {
+		if _, ok := head[m]; !ok {
+			removed = append(removed, m)
+		}
+	}
+	return added, removed
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// beforeModelFor is the model priced in the Before column of a configured estimate.
+func beforeModelFor(in reportInput) string {
+	if in.BaseRef != "" {
+		// Removed diff lines are relative to the merge target, not the baseline ref.
+		return baseAssumptions(in).Model
+	}
+	if len(in.Signals.BeforeModels) > 0 {
+		return in.Signals.BeforeModels[0]
+	}
+	return defaultModelFor(baseAssumptions(in), beforeAssumptions(in).Provider, in.DefaultModels, in.Pricing)
+}
+
+// beforeAssumptions is baseAssumptions under the provider removed by the
+// diff, if any.
+func beforeAssumptions(in reportInput) Assumptions {
+	a := baseAssumptions(in)
+	if in.BaseRef == "" {
+		a.Provider = firstOrDefault(in.Signals.BeforeProviders, a.Provider)
+	}
+	return a
+}
+
+// baseAssumptions returns the Before side's config, which differs from the
+// After side only when the PR edits .plarix.yml or PLARIX_BASE_REF is set.
+func baseAssumptions(in reportInput) Assumptions {
+	if in.BaseConfig != nil {
+		return *in.BaseConfig
+	}
+	return in.Config
+}
+
+// afterModelFor is the model priced in the After column of a configured estimate.
+func afterModelFor(in reportInput) string {
+	if len(in.Signals.AfterModels) > 0 {
+		return in.Signals.AfterModels[0]
+	}
+	return defaultModelFor(in.Config, afterAssumptions(in).Provider, in.DefaultModels, in.Pricing)
+}
+
+// afterAssumptions is the configured assumptions under the provider added by
+// the diff, if any.
+func afterAssumptions(in reportInput) Assumptions {
+	a := in.Config
+	a.Provider = firstOrDefault(in.Signals.AfterProviders, a.Provider)
+	return a
+}
+
+// beforeEstimate prices the Before side of a configured estimate under a,
+// blending the routing weights removed by the diff when there are any.
+func beforeEstimate(in reportInput, a Assumptions) (costPair, bool) {
+	if in.BaseRef == "" && len(in.Signals.BeforeWeights) > 0 {
+		return routedEstimate(a, in.Pricing, in.Signals.BeforeWeights)
+	}
+	return computeEstimate(a, in.Pricing, beforeModelFor(in))
+}
+
+// afterEstimate is beforeEstimate for the After side.
+func afterEstimate(in reportInput, a Assumptions) (costPair, bool) {
+	if len(in.Signals.AfterWeights) > 0 {
+		return routedEstimate(a, in.Pricing, in.Signals.AfterWeights)
+	}
+	return computeEstimate(a, in.Pricing, afterModelFor(in))
+}
+
+// routedEstimate splits a's traffic across weighted models and sums their
+// estimates, so free-token allowances apply to each model's own share.
+func routedEstimate(a Assumptions, pricing PricingFile, weights []modelWeight) (costPair, bool) {
+	var total float64
+	for _, w := range weights {
+		total += w.Weight
+	}
+	cost := costPair{WithinFreeTier: true}
+	found := true
+	for _, w := range weights {
+		share := w.Weight / total
+		part := a
+		part.RequestsPerDay *= share
+		// Routers often mix vendors; a model the configured provider doesn't
+		// list is priced under the provider that does.
+		if a.Provider != "" {
+			if _, ok := priceFor(pricing, a.Provider, w.Model); !ok {
+				price, _ := lookupPrice(pricing, "", w.Model)
+				part.Provider = price.Provider
+			}
+		}
+		c, ok := computeEstimate(part, pricing, w.Model)
+		cost.PerRequest += c.PerRequest * share
+		cost.Monthly += c.Monthly
+		cost.PerUnit += c.PerUnit * share
+		cost.WithinFreeTier = cost.WithinFreeTier && c.WithinFreeTier
+		found = found && ok
+	}
+	return cost, found
+}
+
+// weightsLabel renders routing weights as shares, e.g. "gpt-4o 20%,
+// gpt-4o-mini 80%", or "—" when there are none.
+func weightsLabel(weights []modelWeight) string {
+	var total float64
+	for _, w := range weights {
+		total += w.Weight
+	}
+	parts := make([]string, 0, len(weights))
+	for _, w := range weights {
+		parts = append(parts, fmt.Sprintf("%s %s%%", w.Model, strconv.FormatFloat(math.Round(w.Weight/total*1000)/10, 'f', -1, 64)))
+	}
+	return listOrPlaceholder(parts)
+}
+
+// defaultModelFor keeps the configured model unless provider differs from
+// the configured one, since that model would be priced under the wrong
+// provider; default_models then names the model to use instead.
+func defaultModelFor(cfg Assumptions, provider string, defaults map[string]string, pricing PricingFile) string {
+	if provider != "" && provider != configProvider(cfg, pricing) {
+		if m, ok := defaults[provider]; ok {
+			return m
+		}
+	}
+	return cfg.Model
+}
+
+// budgetResult is the outcome of checking the After cost against the ceiling
+// of a budget label on the PR.
+type budgetResult struct {
+	Label   string
+	Ceiling float64
+	Actual  float64
+	Basis   string // which After figure was compared
+}
+
+func (r budgetResult) Exceeded() bool {
+	return r.Actual > r.Ceiling
+}
+
+// evaluateBudget matches PR labels against the configured budgets and compares
+// the After cost of the active mode: total measured cost in measured mode, or
+// the estimated monthly cost in configured mode. When several labels match,
+// the largest ceiling wins so adding a bigger budget label raises the gate.
+// Heuristic-only runs have no cost to compare and yield nil.
+func evaluateBudget(in reportInput, labels []string, budgets map[string]float64) *budgetResult {
+	var res *budgetResult
+	for _, l := range labels {
+		ceiling, ok := budgets[l]
+		if !ok {
+			continue
+		}
+		if res == nil || ceiling > res.Ceiling {
+			res = &budgetResult{Label: l, Ceiling: ceiling}
+		}
+	}
+	if res == nil {
+		return nil
+	}
+	var ok bool
+	if res.Actual, res.Basis, ok = budgetActual(in); !ok {
+		return nil
+	}
+	return res
+}
+
+// budgetActual is the After cost budgets are checked against.
+func budgetActual(in reportInput) (float64, string, bool) {
+	switch {
+	case in.HeadMeasured != nil:
+		return in.HeadMeasured.TotalCost, "measured", true
+	case in.BaseMeasured != nil:
+		return 0, "", false
+	case in.ConfigFound:
+		cost, _ := afterEstimate(in, afterAssumptions(in))
+		return cost.Monthly, "est. monthly", true
+	}
+	return 0, "", false
+}
+
+// evaluateOrgBudget checks the After cost against this repository's line in
+// an org-wide budget file mapping "owner/repo" to a monthly ceiling in USD.
+// Repositories missing from the file are not gated.
+func evaluateOrgBudget(in reportInput, budgets map[string]float64, repo string) *budgetResult {
+	for name, ceiling := range budgets {
+		if !strings.EqualFold(name, repo) {
+			continue
+		}
+		actual, basis, ok := budgetActual(in)
+		if !ok {
+			return nil
+		}
+		return &budgetResult{Label: name, Ceiling: ceiling, Actual: actual, Basis: basis}
+	}
+	return nil
+}
+
+// fetchOrgBudgets downloads the JSON budget file named by PLARIX_BUDGET_URL.
+func fetchOrgBudgets(url string) (map[string]float64, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	var budgets map[string]float64
+	if err := json.NewDecoder(resp.Body).Decode(&budgets); err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	return budgets, nil
+}
+
+// debugDetails dumps the inputs and intermediate values behind a report as a
+// collapsed block for the step summary. The PR comment never includes it.
+func debugDetails(in reportInput) string {
+	type side struct {
+		Provider   string
+		Model      string
+		Price      *ModelPrice `json:",omitempty"`
+		Estimate   costPair
+		PriceFound bool
+	}
+	resolve := func(a Assumptions, model string) side {
+		sd := side{Provider: a.Provider, Model: model}
+		if price, found := priceFor(in.Pricing, a.Provider, model); found {
+			sd.Price = &price
+		}
+		sd.Estimate, sd.PriceFound = computeEstimate(a, in.Pricing, model)
+		return sd
+	}
+	dump := struct {
+		ConfigFound   bool
+		Config        Assumptions
+		BaseConfig    *Assumptions      `json:",omitempty"`
+		BaseRef       string            `json:",omitempty"`
+		DefaultModels map[string]string `json:",omitempty"`
+		Signals       DiffSignals
+		FilesChanged  int
+		SignalFiles   int
+		Truncated     bool
+		Before, After *side            `json:",omitempty"`
+		BaseMeasured  *MeasuredSummary `json:",omitempty"`
+		HeadMeasured  *MeasuredSummary `json:",omitempty"`
+		Budget        *budgetResult    `json:",omitempty"`
+		OrgBudget     *budgetResult    `json:",omitempty"`
+		PricingDate   string
+		PricingModels int
+	}{
+		ConfigFound:   in.ConfigFound,
+		Config:        in.Config,
+		BaseConfig:    in.BaseConfig,
+		BaseRef:       in.BaseRef,
+		DefaultModels: in.DefaultModels,
+		Signals:       in.Signals,
+		FilesChanged:  in.FilesChanged,
+		SignalFiles:   in.SignalFiles,
+		Truncated:     in.Truncated,
+		BaseMeasured:  in.BaseMeasured,
+		HeadMeasured:  in.HeadMeasured,
+		Budget:        in.Budget,
+		OrgBudget:     in.OrgBudget,
+		PricingDate:   in.Pricing.LastUpdated,
+		PricingModels: len(in.Pricing.Models),
+	}
+	if in.ConfigFound {
+		before := resolve(beforeAssumptions(in), beforeModelFor(in))
+		after := resolve(afterAssumptions(in), afterModelFor(in))
+		dump.Before, dump.After = &before, &after
+	}
+	raw, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		raw = []byte(err.Error())
+	}
+	return fmt.Sprintf("\n<details>\n<summary>debug</summary>\n\n```json\n%s\n```\n\n</details>\n", raw)
+}
+
+// prStatus summarizes a PR report for the plarix/cost commit status: the
+// Before → After delta of the active mode, failing only on an exceeded budget.
+func prStatus(in reportInput) (state, description string) {
+	state = "success"
+	var over *budgetResult
+	for _, r := range []*budgetResult{in.Budget, in.OrgBudget} {
+		if r != nil && r.Exceeded() {
+			state, over = "failure", r
+			break
+		}
+	}
+	before, after, basis, ok := costDelta(in)
+	switch {
+	case ok:
+		description = fmt.Sprintf("%s: %s → %s (%s)", basis, formatCost(before), formatCost(after), signedCost(after-before))
+	case in.HeadMeasured != nil:
+		description = fmt.Sprintf("Measured: %s over %d calls", formatCost(in.HeadMeasured.TotalCost), in.HeadMeasured.CallCount)
+	case in.BaseMeasured != nil:
+		description = fmt.Sprintf("Measured (base only): %s over %d calls", formatCost(in.BaseMeasured.TotalCost), in.BaseMeasured.CallCount)
+	default:
+		description = "Heuristic only: no cost estimate"
+	}
+	if state == "failure" {
+		description += fmt.Sprintf(", over %s budget", over.Label)
+	}
+	return state, description
+}
+
+// costDelta returns the Before and After costs the report compares: measured
+// totals when both logs exist, otherwise the configured monthly estimate. ok
+// is false when the report has no such pair (heuristic-only or one log).
+func costDelta(in reportInput) (before, after float64, basis string, ok bool) {
+	switch {
+	case in.BaseMeasured != nil && in.HeadMeasured != nil:
+		return in.BaseMeasured.TotalCost, in.HeadMeasured.TotalCost, "Measured", true
+	case in.BaseMeasured != nil || in.HeadMeasured != nil:
+		return 0, 0, "", false
+	case in.ConfigFound:
+		b, _ := beforeEstimate(in, beforeAssumptions(in))
+		a, _ := afterEstimate(in, afterAssumptions(in))
+		return b.Monthly, a.Monthly, "Est. monthly", true
+	}
+	return 0, 0, "", false
+}
+
+// isMaterial reports whether before → after moves by at least
+// materialPercent. Every summary and formatting decision about whether a
+// change matters goes through it. Any change from zero is material.
+func isMaterial(before, after float64) bool {
+	if before == 0 {
+		return after != 0
+	}
+	return math.Abs(after-before)/before*100 >= materialPercent
+}
+
+// materialIncrease reports whether after is materially above before.
+func materialIncrease(before, after float64) bool {
+	return after > before && isMaterial(before, after)
+}
+
+// writeDelta renders the Before → After change with a verdict, calling
+// changes under materialPercent roughly flat.
+func writeDelta(b *strings.Builder, before, after float64) {
+	delta := after - before
+	deltaPercent := float64(0)
+	if before > 0 {
+		deltaPercent = (delta / before) * 100
+	}
+	sign := "+"
+	if delta < 0 {
+		sign = ""
+	}
+	verdict := "roughly flat"
+	switch {
+	case !isMaterial(before, after):
+	case delta > 0:
+		verdict = "📈 **material increase**"
+	default:
+		verdict = "📉 material decrease"
+	}
+	fmt.Fprintf(b, "**Delta:** %s%s (%s%.1f%%) — %s\n\n", sign, formatCost(delta), sign, deltaPercent, verdict)
+}
+
+// writeEfficiency compares the blended cost per 1M input and output tokens,
+// which separates a change in rate (model choice, pricing, caching) from a
+// change in volume.
+func writeEfficiency(b *strings.Builder, base, head *MeasuredSummary) {
+	baseTokens := base.TotalInputTokens + base.TotalOutputTokens
+	headTokens := head.TotalInputTokens + head.TotalOutputTokens
+	if baseTokens == 0 || headTokens == 0 {
+		return
+	}
+	before := base.TotalCost / float64(baseTokens) * 1_000_000
+	after := head.TotalCost / float64(headTokens) * 1_000_000
+	verdict := "unchanged"
+	switch {
+	case !isMaterial(before, after):
+	case after > before:
+		verdict = "worse"
+	default:
+		verdict = "better"
+	}
+	change := ""
+	if before > 0 {
+		change = fmt.Sprintf(" (%+.1f%%)", (after-before)/before*100)
+	}
+	fmt.Fprintf(b, "**Cost per 1M tokens:** %s → %s%s — rate %s, independent of volume\n\n", formatCost(before), formatCost(after), change, verdict)
+}
+
+// significanceSigmas is how many combined standard deviations a measured
+// delta must exceed to count as significant rather than noise.
+const significanceSigmas = 2.0
+
+// writeSignificance labels a measured delta "likely noise" when it is within
+// significanceSigmas of the spread expected from per-call cost variation
+// alone (nondeterministic tests produce different token counts run to run),
+// treating each total as a sum of independent calls.
+func writeSignificance(b *strings.Builder, base, head *MeasuredSummary) {
+	if base.CallCount < 2 || head.CallCount < 2 {
+		return
+	}
+	delta := head.TotalCost - base.TotalCost
+	sigma := math.Sqrt(float64(base.CallCount)*base.callCostVariance() + float64(head.CallCount)*head.callCostVariance())
+	switch {
+	case delta == 0:
+		return
+	case sigma == 0:
+		fmt.Fprintf(b, "**Significance:** significant (every call costs the same on each side, so the delta is not noise)\n\n")
+	case math.Abs(delta) > significanceSigmas*sigma:
+		fmt.Fprintf(b, "**Significance:** significant (delta is %.1fσ; σ %s from per-call variation)\n\n", math.Abs(delta)/sigma, formatCost(sigma))
+	default:
+		fmt.Fprintf(b, "**Significance:** likely noise (delta is %.1fσ, within %gσ; σ %s from per-call variation)\n\n", math.Abs(delta)/sigma, significanceSigmas, formatCost(sigma))
+	}
+}
+
+// signedCost formats a cost delta with an explicit sign.
+func signedCost(d float64) string {
+	if d < 0 {
+		return "-" + formatCost(-d)
+	}
+	return "+" + formatCost(d)
+}
+
+// budgetBreachMarker tags reports that exceeded a budget so later runs can
+// minimize them once the PR is back within budget.
+const budgetBreachMarker = "<!-- plarix-over-budget -->"
+
+// stalePricing returns the age in days of pricing's last_updated date and
+// whether it exceeds maxPricingAgeDays. An unreadable date is stale with
+// age -1.
+func stalePricing(pricing PricingFile, now time.Time) (int, bool) {
+	if maxPricingAgeDays <= 0 {
+		return 0, false
+	}
+	updated, err := time.Parse("2006-01-02", pricing.LastUpdated)
+	if err != nil {
+		return -1, true
+	}
+	age := int(now.Sub(updated).Hours() / 24)
+	return age, age > maxPricingAgeDays
+}
+
+// writePricingAge warns near the top of a report when pricing is stale.
+func writePricingAge(b *strings.Builder, pricing PricingFile) {
+	age, stale := stalePricing(pricing, time.Now())
+	switch {
+	case !stale:
+	case age < 0:
+		fmt.Fprintf(b, "> ⚠️ **Pricing date %q can't be read**, so its age is unknown (`PLARIX_MAX_PRICING_AGE_DAYS`).\n\n", pricing.LastUpdated)
+	default:
+		fmt.Fprintf(b, "> ⚠️ **Pricing is %d days old** (limit %d, `PLARIX_MAX_PRICING_AGE_DAYS`); costs may be off. Upgrade Plarix or run `make update-pricing`.\n\n", age, maxPricingAgeDays)
+	}
+}
+
+// failOnStalePricing ends the run with an error when pricing is stale and
+// PLARIX_FAIL_ON_STALE_PRICING is set, after the report has been written.
+func failOnStalePricing(pricing PricingFile) {
+	if age, stale := stalePricing(pricing, time.Now()); stale && envBool("PLARIX_FAIL_ON_STALE_PRICING") {
+		fatalf("plarix: pricing data is stale (%d days old, PLARIX_MAX_PRICING_AGE_DAYS=%d)", age, maxPricingAgeDays)
+	}
+}
+
+func writeOrgBudget(b *strings.Builder, r budgetResult) {
+	fmt.Fprintf(b, "---\n\n")
+	fmt.Fprintf(b, "### 🏢 Org Budget (`%s`)\n\n", r.Label)
+	status := "✅ within budget"
+	if r.Exceeded() {
+		status = "❌ **over budget**" + budgetBreachMarker
+	}
+	used := "n/a"
+	if r.Ceiling > 0 {
+		used = fmt.Sprintf("%.0f%%", r.Actual/r.Ceiling*100)
+	}
+	fmt.Fprintf(b, "Budget: **%s**, projected (%s): **%s** (%s used) — %s\n\n", formatCost(r.Ceiling), r.Basis, formatCost(r.Actual), used, status)
+}
+
+func writeBudget(b *strings.Builder, r budgetResult) {
+	fmt.Fprintf(b, "---\n\n")
+	fmt.Fprintf(b, "### 💰 Budget (`%s`)\n\n", r.Label)
+	status := "✅ within budget"
+	if r.Exceeded() {
+		status = "❌ **over budget**" + budgetBreachMarker
+	}
+	fmt.Fprintf(b, "After %s cost **%s** vs ceiling **%s** — %s\n\n", r.Basis, formatCost(r.Actual), formatCost(r.Ceiling), status)
+}
+
+// writeDiffSignals lists each signal with a severity from its estimated
+// before/after cost ratio; see severity.
+func writeDiffSignals(b *strings.Builder, in reportInput) {
+	s := in.Signals
+	var keptModels []string
+	if in.GroupByFamily {
+		s.BeforeModels = familiesOf(s.BeforeModels, in.Families)
+		s.AfterModels = familiesOf(s.AfterModels, in.Families)
+	}
+	if in.SignalsSummary {
+		s.BeforeModels, s.AfterModels, keptModels = netModels(s.BeforeModels, s.AfterModels)
+	}
+	if headline := signalHeadline(s); headline != "" {
+		fmt.Fprintf(b, "**%s**\n\n", headline)
+	}
+	fmt.Fprintf(b, "**Observed changes (diff-based heuristics):**\n")
+	if len(s.BeforeModels) > 0 || len(s.AfterModels) > 0 {
+		ratio, ok := modelCostRatio(in)
+		fmt.Fprintf(b, "- %s **Models:** %s → %s\n", severity(ratio, ok), listOrPlaceholder(s.BeforeModels), listOrPlaceholder(s.AfterModels))
+	}
+	if len(s.BeforeProviders) > 0 || len(s.AfterProviders) > 0 {
+		fmt.Fprintf(b, "- %s **Providers:** %s → %s\n", severity(0, false), listOrPlaceholder(uniqueStrings(s.BeforeProviders)), listOrPlaceholder(uniqueStrings(s.AfterProviders)))
+	}
+	if len(s.BeforeMax) > 0 || len(s.AfterMax) > 0 {
+		// max_tokens caps output, so its ratio bounds the output cost ratio.
+		ratio, ok := intRatio(s.BeforeMax, s.AfterMax, 0)
+		fmt.Fprintf(b, "- %s **max_tokens:** %s → %s\n", severity(ratio, ok), intsOrDash(s.BeforeMax), intsOrDash(s.AfterMax))
+	}
+	if len(s.BeforeRetry) > 0 || len(s.AfterRetry) > 0 {
+		ratio, ok := intRatio(s.BeforeRetry, s.AfterRetry, 1)
+		fmt.Fprintf(b, "- %s **retries:** %s → %s\n", severity(ratio, ok), intsOrDash(s.BeforeRetry), intsOrDash(s.AfterRetry))
+	}
+	if len(s.BeforeContext) > 0 || len(s.AfterContext) > 0 {
+		ratio, ok := intRatio(s.BeforeContext, s.AfterContext, 0)
+		fmt.Fprintf(b, "- %s **Context length:** %s → %s\n", severity(ratio, ok), intsOrDash(s.BeforeContext), intsOrDash(s.AfterContext))
+	}
+	if len(s.BeforeConcurrency) > 0 || len(s.AfterConcurrency) > 0 {
+		// More workers multiply throughput, and cost, when they are the bottleneck.
+		ratio, ok := intRatio(s.BeforeConcurrency, s.AfterConcurrency, 0)
+		fmt.Fprintf(b, "- %s **Concurrency:** %s → %s\n", severity(ratio, ok), intsOrDash(s.BeforeConcurrency), intsOrDash(s.AfterConcurrency))
+	}
+	if len(s.BeforeBaseURLs) > 0 || len(s.AfterBaseURLs) > 0 {
+		// A proxy or regional endpoint may bill differently from list rates.
+		fmt.Fprintf(b, "- %s **Base URL:** %s → %s _(Plarix prices at provider list rates and can't price proxies or other endpoints; check the new endpoint's pricing)_\n",
+			severity(0, false), listOrPlaceholder(s.BeforeBaseURLs), listOrPlaceholder(s.AfterBaseURLs))
+	}
+	if len(s.BeforeWeights) > 0 || len(s.AfterWeights) > 0 {
+		ratio, ok := 0.0, false
+		if in.ConfigFound {
+			before, bFound := beforeEstimate(in, beforeAssumptions(in))
+			after, aFound := afterEstimate(in, afterAssumptions(in))
+			ratio, ok = after.PerRequest/before.PerRequest, bFound && aFound && before.PerRequest > 0
+		}
+		fmt.Fprintf(b, "- %s **Routing weights:** %s → %s\n", severity(ratio, ok), weightsLabel(s.BeforeWeights), weightsLabel(s.AfterWeights))
+	}
+	if s.CallSitesRemoved != s.CallSitesAdded {
+		// Fewer calls per request is a cost cut; the ratio assumes equal-cost calls.
+		ratio, ok := float64(s.CallSitesAdded)/float64(max(s.CallSitesRemoved, 1)), s.CallSitesRemoved > 0
+		change := fmt.Sprintf("%d removed", s.CallSitesRemoved-s.CallSitesAdded)
+		if s.CallSitesAdded > s.CallSitesRemoved {
+			change = fmt.Sprintf("%d added", s.CallSitesAdded-s.CallSitesRemoved)
+		}
+		fmt.Fprintf(b, "- %s **LLM call sites:** %s (-%d / +%d)\n", severity(ratio, ok), change, s.CallSitesRemoved, s.CallSitesAdded)
+	}
+	if s.PromptCharsRemoved > 0 || s.PromptCharsAdded > 0 {
+		net := ModelPrice{}.CharTokens(s.PromptCharsAdded - s.PromptCharsRemoved)
+		ratio, ok := 0.0, in.ConfigFound && in.Config.AvgInputTokens > 0
+		if ok {
+			ratio = float64(max(in.Config.AvgInputTokens+net, 0)) / float64(in.Config.AvgInputTokens)
+		}
+		fmt.Fprintf(b, "- %s **System prompt:** -%s / +%s chars (net ~%+d tokens per call)\n",
+			severity(ratio, ok), formatInt(s.PromptCharsRemoved), formatInt(s.PromptCharsAdded), net)
+	}
+	fmt.Fprintf(b, "\n")
+	if len(keptModels) > 0 {
+		fmt.Fprintf(b, "<details><summary>%d model(s) on both sides</summary>\n\n%s\n\n</details>\n\n", len(keptModels), strings.Join(keptModels, ", "))
+	}
+	if overruns := maxTokenOverruns(s, in.Pricing); len(overruns) > 0 {
+		for _, o := range overruns {
+			fmt.Fprintf(b, "⚠️ **max_tokens over model limit:** %s\n", o)
+		}
+		fmt.Fprintf(b, "\n")
+	}
+}
+
+// signalsSummary reads PLARIX_SIGNALS_VERBOSITY: "summary" lists only the
+// models a PR adds or drops, "full" (the default) every model seen.
+func signalsSummary() bool {
+	switch v := strings.ToLower(strings.TrimSpace(os.Getenv("PLARIX_SIGNALS_VERBOSITY"))); v {
+	case "", "full":
+		return false
+	case "summary":
+		return true
+	default:
+		fmt.Fprintf(os.Stderr, "warn: ignoring invalid PLARIX_SIGNALS_VERBOSITY=%q (want summary or full)\n", v)
+		return false
+	}
+}
+
+// netModels splits the distinct models into those only on the Before side,
+// those only on the After side, and those on both.
+func netModels(before, after []string) (removed, added, kept []string) {
+	inAfter := make(map[string]bool, len(after))
+	for _, m := range after {
+		inAfter[m] = true
+	}
+	inBefore := make(map[string]bool, len(before))
+	for _, m := range uniqueStrings(before) {
+		inBefore[m] = true
+		if inAfter[m] {
+			kept = append(kept, m)
+		} else {
+			removed = append(removed, m)
+		}
+	}
+	for _, m := range uniqueStrings(after) {
+		if !inBefore[m] {
+			added = append(added, m)
+		}
+	}
+	return removed, added, kept
+}
+
+// datedSuffixPattern matches snapshot suffixes such as -20241022,
+// -2024-08-06, -latest and -preview that don't change a model's family.
+var datedSuffixPattern = regexp.MustCompile(`(?:-(?:\d{8}|\d{4}-\d{2}-\d{2}|\d{4}|latest|preview(?:-[\w-]*)?|exp(?:-[\w-]*)?))+$`)
+
+// familyFor maps a model to its family: the configured families entry
+// (exact name, then glob) or else derivedFamily.
+func familyFor(model string, families map[string]string) string {
+	name := strings.ToLower(strings.TrimSpace(model))
+	if f, ok := families[name]; ok {
+		return f
+	}
+	for _, pattern := range sortedKeys(families) {
+		if ok, _ := path.Match(pattern, name); ok {
+			return families[pattern]
+		}
+	}
+	return derivedFamily(name)
+}
+
+// derivedFamily groups a model by vendor naming: Claude and Gemini by tier
+// (claude-sonnet, gemini-flash), GPT by major version and o-series together,
+// each with a -mini class for small variants. Other names keep their SKU
+// without snapshot suffixes or OpenRouter vendor prefixes.
+func derivedFamily(name string) string {
+	name = strings.ToLower(name)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = datedSuffixPattern.ReplaceAllString(name, "")
+	small := ""
+	if strings.Contains(name, "-mini") || strings.Contains(name, "-nano") {
+		small = "-mini"
+	}
+	switch {
+	case strings.HasPrefix(name, "claude"):
+		for _, tier := range []string{"opus", "sonnet", "haiku"} {
+			if strings.Contains(name, tier) {
+				return "claude-" + tier
+			}
+		}
+	case strings.HasPrefix(name, "gemini"):
+		for _, tier := range []string{"flash", "pro", "ultra"} {
+			if strings.Contains(name, tier) {
+				return "gemini-" + tier
+			}
+		}
+	case strings.HasPrefix(name, "gpt-3.5"):
+		return "gpt-3.5"
+	case strings.HasPrefix(name, "gpt-"):
+		if major, _, _ := strings.Cut(strings.TrimPrefix(name, "gpt-"), "-"); major != "" && major[0] >= '0' && major[0] <= '9' {
+			return "gpt-" + strings.TrimRight(strings.Split(major, ".")[0], "o") + small
+		}
+	case len(name) > 1 && name[0] == 'o' && name[1] >= '0' && name[1] <= '9':
+		return "o-series" + small
+	}
+	return name
+}
+
+// familiesOf maps models to their distinct families, in order.
+func familiesOf(models []string, families map[string]string) []string {
+	out := make([]string, 0, len(models))
+	for _, m := range models {
+		out = append(out, familyFor(m, families))
+	}
+	return uniqueStrings(out)
+}
+
+// maxTokenOverruns describes each added max_tokens above the documented
+// max_output_tokens of the nearest model in its hunk; the API rejects such
+// calls.
+func maxTokenOverruns(s DiffSignals, pricing PricingFile) []string {
+	var out []string
+	for _, p := range s.MaxTokenPairs {
+		price, found := lookupPrice(pricing, "", p.Model)
+		if !found || price.MaxOutputTokens == 0 || p.MaxTokens <= price.MaxOutputTokens {
+			continue
+		}
+		out = append(out, fmt.Sprintf("`%s:%d` sets %s but %s allows at most %s output tokens",
+			p.File, p.Line, groupThousands(strconv.Itoa(p.MaxTokens)), p.Model, groupThousands(strconv.Itoa(price.MaxOutputTokens))))
+	}
+	return out
+}
+
+// signalHeadline condenses the signals into one line such as
+// "gpt-4o → gpt-4o-mini | max_tokens 512→2048 | retries 2→3", leaving out
+// dimensions whose Before and After values match.
+func signalHeadline(s DiffSignals) string {
+	var parts []string
+	if before, after := strings.Join(uniqueStrings(s.BeforeModels), ", "), strings.Join(uniqueStrings(s.AfterModels), ", "); before != after {
+		parts = append(parts, fmt.Sprintf("%s → %s", safeValue(before, "—"), safeValue(after, "—")))
+	}
+	if before, after := strings.Join(uniqueStrings(s.BeforeProviders), ", "), strings.Join(uniqueStrings(s.AfterProviders), ", "); before != after {
+		parts = append(parts, fmt.Sprintf("provider %s→%s", safeValue(before, "—"), safeValue(after, "—")))
+	}
+	if before, after := intsOrDash(s.BeforeMax), intsOrDash(s.AfterMax); before != after {
+		parts = append(parts, fmt.Sprintf("max_tokens %s→%s", before, after))
+	}
+	if before, after := intsOrDash(s.BeforeRetry), intsOrDash(s.AfterRetry); before != after {
+		parts = append(parts, fmt.Sprintf("retries %s→%s", before, after))
+	}
+	if before, after := intsOrDash(s.BeforeContext), intsOrDash(s.AfterContext); before != after {
+		parts = append(parts, fmt.Sprintf("context %s→%s", before, after))
+	}
+	if before, after := intsOrDash(s.BeforeConcurrency), intsOrDash(s.AfterConcurrency); before != after {
+		parts = append(parts, fmt.Sprintf("concurrency %s→%s", before, after))
+	}
+	if len(s.BeforeBaseURLs) > 0 || len(s.AfterBaseURLs) > 0 {
+		parts = append(parts, "base URL changed")
+	}
+	if len(s.BeforeWeights) > 0 || len(s.AfterWeights) > 0 {
+		parts = append(parts, "routing weights changed")
+	}
+	if net := s.CallSitesAdded - s.CallSitesRemoved; net < 0 {
+		parts = append(parts, fmt.Sprintf("%d LLM call sites removed", -net))
+	} else if net > 0 {
+		parts = append(parts, fmt.Sprintf("%d LLM call sites added", net))
+	}
+	if net := s.PromptCharsAdded - s.PromptCharsRemoved; net != 0 {
+		parts = append(parts, fmt.Sprintf("system prompt %+d tokens", ModelPrice{}.CharTokens(net)))
+	}
+	return strings.Join(parts, " | ")
+}
+
+// severity labels a signal by its After/Before cost ratio. Signals without
+// a computable ratio get a neutral marker.
+func severity(ratio float64, ok bool) string {
+	switch {
+	case !ok:
+		return "⚪"
+	case ratio >= 3:
+		return "🔴 high"
+	case ratio >= 1.5:
+		return "🟠 medium"
+	case ratio > 1:
+		return "🟡 low"
+	case ratio < 1:
+		return "🟢 saving"
+	default:
+		return "⚪ none"
+	}
+}
+
+// modelCostRatio compares the first After model to the first Before model,
+// per request at configured token sizes or by combined list rates otherwise.
+func modelCostRatio(in reportInput) (float64, bool) {
+	if len(in.Signals.BeforeModels) == 0 || len(in.Signals.AfterModels) == 0 {
+		return 0, false
+	}
+	before, bFound := lookupPrice(in.Pricing, in.Config.Provider, in.Signals.BeforeModels[0])
+	after, aFound := lookupPrice(in.Pricing, in.Config.Provider, in.Signals.AfterModels[0])
+	if !bFound || !aFound {
+		return 0, false
+	}
+	beforeCost := before.InputPerMillion + before.OutputPerMillion
+	afterCost := after.InputPerMillion + after.OutputPerMillion
+	if in.ConfigFound {
+		beforeCost = before.Cost(in.Config.AvgInputTokens, in.Config.AvgOutputTokens)
+		afterCost = after.Cost(in.Config.AvgInputTokens, in.Config.AvgOutputTokens)
+	}
+	if beforeCost <= 0 {
+		return 0, false
+	}
+	return afterCost / beforeCost, true
+}
+
+// intRatio compares the largest After value to the largest Before value,
+// each plus offset (1 for retries, where n retries mean n+1 attempts).
+func intRatio(before, after []int, offset int) (float64, bool) {
+	if len(before) == 0 || len(after) == 0 {
+		return 0, false
+	}
+	b := slices.Max(before) + offset
+	if b <= 0 {
+		return 0, false
+	}
+	return float64(slices.Max(after)+offset) / float64(b), true
+}
+
+// retryImpact describes how much a retry increase could multiply worst-case
+// cost. Each request makes at most retries+1 billed attempts, so the ratio of
+// attempts is an upper bound that assumes every attempt fails until the last.
+func retryImpact(s DiffSignals) (string, bool) {
+	if len(s.BeforeRetry) == 0 || len(s.AfterRetry) == 0 {
+		return "", false
+	}
+	before, after := slices.Max(s.BeforeRetry), slices.Max(s.AfterRetry)
+	if after <= before {
+		return "", false
+	}
+	factor := float64(after+1) / float64(before+1)
+	return fmt.Sprintf("**Retry impact:** retries %d→%d could increase worst-case cost up to %.1fx (%d→%d attempts per request; upper bound, assumes every retry is used).",
+		before, after, factor, before+1, after+1), true
+}
+
+// concurrencyImpact prices the configured volume scaled by a concurrency
+// change, the most it could move monthly cost: throughput only scales when
+// workers are the bottleneck and there is demand to serve.
+func concurrencyImpact(in reportInput) (string, bool) {
+	s := in.Signals
+	if len(s.BeforeConcurrency) == 0 || len(s.AfterConcurrency) == 0 {
+		return "", false
+	}
+	before, after := slices.Max(s.BeforeConcurrency), slices.Max(s.AfterConcurrency)
+	if after <= before {
+		return "", false
+	}
+	factor := float64(after) / float64(before)
+	a := afterAssumptions(in)
+	base, baseFound := afterEstimate(in, a)
+	a.RequestsPerDay *= factor
+	cost, found := afterEstimate(in, a)
+	if !baseFound || !found {
+		return "", false
+	}
+	return fmt.Sprintf("**Concurrency impact:** concurrency %d→%d (%.1fx) could raise volume up to %s requests/day → est. monthly %s → %s (upper bound; only if workers are the bottleneck and demand keeps up).",
+		before, after, factor, formatVolume(a.RequestsPerDay), formatCost(base.Monthly), formatCost(cost.Monthly)), true
+}
+
+// contextImpact models a context-length change compounding with the model
+// change: After input tokens are scaled by the After/Before context ratio
+// (capped at the new context) and priced at the After model, against the
+// Before estimate. Scaling assumes prompts fill the window proportionally,
+// e.g. more retrieved documents, so it is a scenario rather than a forecast.
+func contextImpact(in reportInput) (string, bool) {
+	s := in.Signals
+	if len(s.BeforeContext) == 0 || len(s.AfterContext) == 0 {
+		return "", false
+	}
+	before, after := slices.Max(s.BeforeContext), slices.Max(s.AfterContext)
+	if before <= 0 || after == before {
+		return "", false
+	}
+	factor := float64(after) / float64(before)
+	a := afterAssumptions(in)
+	scaled := a
+	scaled.AvgInputTokens = min(int(math.Round(float64(a.AvgInputTokens)*factor)), after)
+	base, baseFound := beforeEstimate(in, beforeAssumptions(in))
+	cost, found := afterEstimate(in, scaled)
+	if !baseFound || !found {
+		return "", false
+	}
+	return fmt.Sprintf("**Context scenario:** context %s→%s (%.1fx) with input scaled to %s tokens per call → est. monthly %s → %s (%s) combined with the model change (not included in the estimate above).",
+		formatInt(before), formatInt(after), factor, formatInt(scaled.AvgInputTokens),
+		formatCost(base.Monthly), formatCost(cost.Monthly), signedCost(cost.Monthly-base.Monthly)), true
+}
+
+// promptImpact prices the system-prompt size change as extra input tokens on
+// every call at the After model's rates and configured volume.
+func promptImpact(in reportInput) (string, bool) {
+	net := in.Signals.PromptCharsAdded - in.Signals.PromptCharsRemoved
+	if net == 0 {
+		return "", false
+	}
+	a, model := afterAssumptions(in), afterModelFor(in)
+	price, found := priceFor(in.Pricing, a.Provider, model)
+	if !found {
+		return "", false
+	}
+	delta := price.CharTokens(net)
+	perCall := price.Cost(max(a.AvgInputTokens+delta, 0), a.AvgOutputTokens) - price.Cost(a.AvgInputTokens, a.AvgOutputTokens)
+	return fmt.Sprintf("**System prompt impact:** ~%+d input tokens per call → %s/request, %s/month at %s (not included in the estimate above).",
+		delta, signedCost(perCall), signedCost(perCall*a.RequestsPerDay*30), model), true
+}
+
+type rankedModel struct {
+	Model string
+	Price ModelPrice
+	Cost  costPair
+	Found bool
+}
+
+// rankAddedModels orders models that appear only on the After side by
+// estimated cost per request under a, most expensive first. With zero token
+// sizes the order falls back to combined list rates. Unpriced models sort last.
+func rankAddedModels(s DiffSignals, a Assumptions, pricing PricingFile) []rankedModel {
+	before := make(map[string]bool, len(s.BeforeModels))
+	for _, m := range s.BeforeModels {
+		before[strings.ToLower(m)] = true
+	}
+	var ranked []rankedModel
+	for _, m := range uniqueStrings(s.AfterModels) {
+		if before[strings.ToLower(m)] {
+			continue
+		}
+		price, found := lookupPrice(pricing, a.Provider, m)
+		est := a
+		est.Provider = price.Provider
+		cost, _ := computeEstimate(est, pricing, price.Name)
+		ranked = append(ranked, rankedModel{Model: m, Price: price, Cost: cost, Found: found})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Found != ranked[j].Found {
+			return ranked[i].Found
+		}
+		if ranked[i].Cost.PerRequest != ranked[j].Cost.PerRequest {
+			return ranked[i].Cost.PerRequest > ranked[j].Cost.PerRequest
+		}
+		return ranked[i].Price.InputPerMillion+ranked[i].Price.OutputPerMillion > ranked[j].Price.InputPerMillion+ranked[j].Price.OutputPerMillion
+	})
+	return ranked
+}
 
-		if len(in.HeadMeasured.Models) > 0 {
-			models := make([]string, 0, len(in.HeadMeasured.Models))
-			for m := range in.HeadMeasured.Models {
-				models = append(models, m)
+// watchedAdditions returns the After-side models matching a watch_models
+// entry (case-insensitive name or glob) that the Before side did not use.
+func watchedAdditions(s DiffSignals, watch []string) []string {
+	if len(watch) == 0 {
+		return nil
+	}
+	before := make(map[string]bool, len(s.BeforeModels))
+	for _, m := range s.BeforeModels {
+		before[strings.ToLower(m)] = true
+	}
+	var added []string
+	for _, m := range uniqueStrings(s.AfterModels) {
+		name := strings.ToLower(m)
+		if before[name] {
+			continue
+		}
+		for _, w := range watch {
+			if ok, _ := path.Match(strings.ToLower(w), name); ok {
+				added = append(added, m)
+				break
 			}
-			fmt.Fprintf(b, "**Models used:** %s\n\n", strings.Join(models, ", "))
 		}
-		fmt.Fprintf(b, "_Note: Only HEAD measurement available. Set `PLARIX_MEASURE_BASE` to enable before/after comparison._\n\n")
-	} else if in.BaseMeasured != nil {
-		// Only base measured
-		fmt.Fprintf(b, "| Calls | Input Tokens | Output Tokens | Total Cost |\n")
-		fmt.Fprintf(b, "|---:|---:|---:|---:|\n")
-		fmt.Fprintf(b, "| %d | %s | %s | $%.4f |\n\n",
-			in.BaseMeasured.CallCount,
-			formatInt(in.BaseMeasured.TotalInputTokens),
-			formatInt(in.BaseMeasured.TotalOutputTokens),
-			in.BaseMeasured.TotalCost)
+	}
+	return added
+}
 
-		fmt.Fprintf(b, "_Note: Only BASE measurement available. Set `PLARIX_MEASURE_HEAD` to enable before/after comparison._\n\n")
+// configProvider is the provider a config sets, or the one its model is
+// priced under when the config names only a model; "" when neither is known.
+func configProvider(a Assumptions, pricing PricingFile) string {
+	if a.Provider != "" {
+		return a.Provider
 	}
+	price, _ := lookupPrice(pricing, "", a.Model)
+	return price.Provider
+}
 
-	// Also show diff signals if any
-	if hasAnySignals(in.Signals) {
-		fmt.Fprintf(b, "---\n\n")
-		writeDiffSignals(b, in.Signals)
+// lookupPrice is priceFor with a fallback to any provider listing the model,
+// for diff signals whose provider differs from the configured one.
+func lookupPrice(pricing PricingFile, provider, model string) (ModelPrice, bool) {
+	// Without a provider, skip priceFor's inference and its warning: diff
+	// signals and raw SDK logs never carry one.
+	if provider != "" {
+		if price, found := priceFor(pricing, provider, model); found {
+			return price, true
+		}
+	}
+	for _, m := range pricing.Models {
+		if price, found := priceFor(pricing, m.Provider, model); found {
+			return price, true
+		}
 	}
+	return ModelPrice{Provider: provider, Name: model}, false
 }
 
-func buildConfiguredEstimateReport(b *strings.Builder, in reportInput, hasSignals bool) {
-	fmt.Fprintf(b, "### 📋 Configured Estimate (from .plarix.yml)\n\n")
+func hasAnySignals(s DiffSignals) bool {
+	return len(s.BeforeModels)+len(s.AfterModels)+len(s.BeforeProviders)+len(s.AfterProviders)+len(s.BeforeMax)+len(s.AfterMax)+len(s.BeforeRetry)+len(s.AfterRetry)+len(s.BeforeContext)+len(s.AfterContext)+len(s.BeforeConcurrency)+len(s.AfterConcurrency)+len(s.BeforeBaseURLs)+len(s.AfterBaseURLs)+len(s.BeforeWeights)+len(s.AfterWeights)+s.CallSitesRemoved+s.CallSitesAdded+s.PromptCharsRemoved+s.PromptCharsAdded > 0
+}
 
-	// Show assumptions explicitly
-	fmt.Fprintf(b, "**Assumptions from config:**\n")
-	fmt.Fprintf(b, "- Requests/day: %d\n", in.Config.RequestsPerDay)
-	fmt.Fprintf(b, "- Avg input tokens: %d\n", in.Config.AvgInputTokens)
-	fmt.Fprintf(b, "- Avg output tokens: %d\n", in.Config.AvgOutputTokens)
-	fmt.Fprintf(b, "- Provider: %s\n", in.Config.Provider)
-	fmt.Fprintf(b, "- Model: %s\n\n", in.Config.Model)
+func bar(value, max float64) string {
+	width := 22
+	filled := int((value / max) * float64(width))
+	if filled < 1 && value > 0 {
+		filled = 1
+	}
+	if filled > width {
+		filled = width
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("·", width-filled)
+}
 
-	beforeModel := firstOrDefault(in.Signals.BeforeModels, in.Config.Model)
-	afterModel := firstOrDefault(in.Signals.AfterModels, in.Config.Model)
+// formatCost renders USD with precision that suits the magnitude: whole
+// dollars with thousands separators from $1,000, cents from $1, four decimals
+// down to a cent, and two significant digits below that so tiny but real
+// costs never collapse to $0.0000.
+func formatCost(v float64) string {
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	switch {
+	case v == 0:
+		return "$0.00"
+	case v >= 1000:
+		return sign + "$" + groupThousands(roundDecimal(v, 0))
+	case v >= 1:
+		return sign + "$" + roundDecimal(v, 2)
+	case v >= 0.01:
+		return sign + "$" + roundDecimal(v, 4)
+	}
+	decimals := int(-math.Floor(math.Log10(v))) + 1
+	if decimals > 10 {
+		decimals = 10
+	}
+	return sign + "$" + roundDecimal(v, decimals)
+}
 
-	beforeCost, beforeFound := computeEstimate(in.Config, in.Pricing, beforeModel)
-	afterCost, afterFound := computeEstimate(in.Config, in.Pricing, afterModel)
+// costRounding is how costs round at their last shown decimal: "half-up"
+// (ties away from zero) or "half-even" (banker's rounding), set by
+// PLARIX_COST_ROUNDING.
+var costRounding = "half-up"
 
-	// Show formula
-	fmt.Fprintf(b, "**Formula:** `cost = (input_tokens × input_price + output_tokens × output_price) / 1M × requests/day × 30`\n\n")
+// roundDecimal formats v with the given number of decimals. It rounds the
+// decimal value v prints as (so 2.675 is a tie, not 2.67499...) according
+// to costRounding; every cost shown or output goes through it.
+func roundDecimal(v float64, decimals int) string {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(math.Abs(v), 'f', -1, 64))
+	if !ok {
+		return strconv.FormatFloat(v, 'f', decimals, 64) // NaN or ±Inf
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	switch rem.Lsh(rem, 1).Cmp(r.Denom()) {
+	case 1:
+		q.Add(q, big.NewInt(1))
+	case 0:
+		if costRounding != "half-even" || q.Bit(0) == 1 {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	digits := q.String()
+	if decimals > 0 {
+		if len(digits) <= decimals {
+			digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-decimals] + "." + digits[len(digits)-decimals:]
+	}
+	if v < 0 && q.Sign() != 0 {
+		digits = "-" + digits
+	}
+	return digits
+}
 
-	// Cost table
-	fmt.Fprintf(b, "| | Model | Est. per request | Est. monthly |\n")
-	fmt.Fprintf(b, "|---|---|---:|---:|\n")
-	fmt.Fprintf(b, "| Before | %s | $%.4f | $%.2f |\n", beforeModel, beforeCost.PerRequest, beforeCost.Monthly)
-	fmt.Fprintf(b, "| After | %s | $%.4f | $%.2f |\n\n", afterModel, afterCost.PerRequest, afterCost.Monthly)
+// formatVolume prints a request rate without exponent notation, grouping
+// the integer part (25000000 → 25,000,000; 0.5 stays 0.5).
+func formatVolume(v float64) string {
+	whole, frac, _ := strings.Cut(strconv.FormatFloat(v, 'f', -1, 64), ".")
+	if frac != "" {
+		frac = "." + frac
+	}
+	return groupThousands(whole) + frac
+}
 
-	// Trend bar
-	maxMonthly := beforeCost.Monthly
-	if afterCost.Monthly > maxMonthly {
-		maxMonthly = afterCost.Monthly
+// groupThousands inserts commas into a string of digits.
+func groupThousands(digits string) string {
+	var b strings.Builder
+	for i, r := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
 	}
-	if maxMonthly == 0 {
-		maxMonthly = 1
+	return b.String()
+}
+
+func formatInt(n int) string {
+	if exactTokens {
+		if n < 0 {
+			return "-" + groupThousands(strconv.Itoa(-n))
+		}
+		return groupThousands(strconv.Itoa(n))
 	}
-	fmt.Fprintf(b, "```\n")
-	fmt.Fprintf(b, "Before |%s $%.2f\n", bar(beforeCost.Monthly, maxMonthly), beforeCost.Monthly)
-	fmt.Fprintf(b, "After  |%s $%.2f\n", bar(afterCost.Monthly, maxMonthly), afterCost.Monthly)
-	fmt.Fprintf(b, "```\n\n")
+	// 999,960 would round to "1000.0K", so promote it to M.
+	if n >= 999_950 {
+		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	}
+	if n >= 1_000 {
+		return fmt.Sprintf("%.1fK", float64(n)/1_000)
+	}
+	return strconv.Itoa(n)
+}
 
-	if !beforeFound || !afterFound {
-		fmt.Fprintf(b, "_⚠️ Pricing not found for one or more models; costs may be $0.00._\n\n")
+func mergeModelCounts(a, b map[string]int) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for m := range a {
+		if !seen[m] {
+			seen[m] = true
+			result = append(result, m)
+		}
+	}
+	for m := range b {
+		if !seen[m] {
+			seen[m] = true
+			result = append(result, m)
+		}
 	}
+	return result
+}
 
-	fmt.Fprintf(b, "_⚠️ These are **estimates** based on configured assumptions, not actual usage._\n\n")
+func listOrPlaceholder(values []string) string {
+	if len(values) == 0 {
+		return "—"
+	}
+	return strings.Join(uniqueStrings(values), ", ")
+}
 
-	// Diff signals
-	if hasSignals {
-		fmt.Fprintf(b, "---\n\n")
-		writeDiffSignals(b, in.Signals)
+func intsOrDash(values []int) string {
+	if len(values) == 0 {
+		return "—"
+	}
+	parts := make([]string, 0, len(values))
+	for _, v := range uniqueInts(values) {
+		parts = append(parts, strconv.Itoa(v))
 	}
+	return strings.Join(parts, ", ")
 }
 
-func buildHeuristicOnlyReport(b *strings.Builder, in reportInput, hasSignals bool) {
-	fmt.Fprintf(b, "### ⚠️ Cannot Compute Real Cost\n\n")
-	fmt.Fprintf(b, "**No `.plarix.yml` config and no measured token logs found.**\n\n")
-	fmt.Fprintf(b, "Without configuration or measurements, we cannot estimate costs reliably.\n\n")
+func uniqueStrings(in []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, v := range in {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
 
-	if hasSignals {
-		fmt.Fprintf(b, "---\n\n")
-		fmt.Fprintf(b, "### 🔍 Detected PR Signals (diff-based heuristics)\n\n")
-		writeDiffSignals(b, in.Signals)
-	} else {
-		fmt.Fprintf(b, "No LLM-cost-relevant changes detected in this PR.\n\n")
+func uniqueInts(in []int) []int {
+	seen := map[int]bool{}
+	var out []int
+	for _, v := range in {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
 	}
+	return out
+}
 
-	// How to enable
-	fmt.Fprintf(b, "---\n\n")
-	fmt.Fprintf(b, "### 📖 How to Enable Real Reporting\n\n")
-	fmt.Fprintf(b, "**Option 1: Configured Estimate** (quick setup)\n")
-	fmt.Fprintf(b, "Create `.plarix.yml` in your repo root:\n")
-	fmt.Fprintf(b, "```yaml\n")
-	fmt.Fprintf(b, "assumptions:\n")
-	fmt.Fprintf(b, "  requests_per_day: 10000\n")
-	fmt.Fprintf(b, "  avg_input_tokens: 800\n")
-	fmt.Fprintf(b, "  avg_output_tokens: 400\n")
-	fmt.Fprintf(b, "  provider: \"openai\"\n")
-	fmt.Fprintf(b, "  model: \"gpt-4o-mini\"\n")
-	fmt.Fprintf(b, "```\n\n")
-	fmt.Fprintf(b, "**Option 2: Measured Mode** (most accurate)\n")
-	fmt.Fprintf(b, "Instrument your tests to log token usage to JSONL files, then set:\n")
-	fmt.Fprintf(b, "- `PLARIX_MEASURE_BASE` = path to base branch usage log\n")
-	fmt.Fprintf(b, "- `PLARIX_MEASURE_HEAD` = path to PR head usage log\n\n")
-	fmt.Fprintf(b, "See [plarix-action README](https://github.com/aegix-ai/plarix-action) for detailed setup.\n")
+// hitRateLabel formats a cache hit rate fraction as a percentage.
+func hitRateLabel(rate float64) string {
+	return strconv.FormatFloat(rate*100, 'f', -1, 64) + "%"
 }
 
-func writeDiffSignals(b *strings.Builder, s DiffSignals) {
-	fmt.Fprintf(b, "**Observed changes (diff-based heuristics):**\n")
-	if len(s.BeforeModels) > 0 || len(s.AfterModels) > 0 {
-		fmt.Fprintf(b, "- **Models:** %s → %s\n", listOrPlaceholder(s.BeforeModels), listOrPlaceholder(s.AfterModels))
+// changeOf renders "a → b", or just "a" when the value is unchanged.
+func changeOf(before, after string) string {
+	if before == after {
+		return before
+	}
+	return before + " → " + after
+}
+
+func safeValue(val, fallback string) string {
+	if strings.TrimSpace(val) == "" {
+		return fallback
+	}
+	return val
+}
+
+func firstOrDefault(list []string, fallback string) string {
+	if len(list) == 0 {
+		return fallback
+	}
+	return list[0]
+}
+
+// commentOptions controls how upsertComment treats existing comments.
+type commentOptions struct {
+	HistoryLimit int  // earlier analyses kept below historyDivider
+	Dedupe       bool // delete older duplicate Plarix comments
+	Append       bool // post a new comment per run instead of editing one
+	Keep         int  // in append mode, Plarix comments kept; 0 keeps all
+	// MinimizeResolved collapses older comments that reported a budget
+	// breach once the new report is within budget.
+	MinimizeResolved bool
+	// UpdateOnly edits an existing comment but never creates one.
+	UpdateOnly bool
+}
+
+func upsertComment(ctx context.Context, client *http.Client, repo string, prNumber int, body string, opts commentOptions) error {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok {
+		return fmt.Errorf("invalid repo: %s", repo)
+	}
+	existing, err := findExistingComments(ctx, client, owner, name, prNumber)
+	if err != nil {
+		return err
+	}
+	// The setup guide is shown in full only on a PR's first Plarix comment.
+	for _, c := range existing {
+		if strings.Contains(c.Body, onboardingStart) {
+			body = collapseOnboarding(body)
+			break
+		}
+	}
+	if opts.UpdateOnly {
+		// In append mode a repeated one-liner would only add noise.
+		if len(existing) == 0 || (opts.Append && existing[len(existing)-1].Body == body) {
+			return nil
+		}
+	}
+	if opts.Append {
+		kept, err := appendComment(ctx, client, owner, name, prNumber, body, existing, opts.Keep)
+		if err == nil && opts.MinimizeResolved {
+			minimizeResolved(ctx, client, kept, body)
+		}
+		return err
+	}
+	if len(existing) == 0 {
+		return createComment(ctx, client, owner, name, prNumber, body)
+	}
+
+	// Comments come back oldest first; keep the newest and treat the rest as
+	// leftovers from earlier runs.
+	newest := existing[len(existing)-1]
+	if stale := existing[:len(existing)-1]; len(stale) > 0 {
+		if !opts.Dedupe {
+			fmt.Fprintf(os.Stderr, "warn: found %d duplicate Plarix comments; set PLARIX_DEDUPE_COMMENTS=true to remove them\n", len(stale))
+			if opts.MinimizeResolved {
+				minimizeResolved(ctx, client, stale, body)
+			}
+		} else {
+			for _, c := range stale {
+				if err := deleteComment(ctx, client, owner, name, c.ID); err != nil {
+					fmt.Fprintf(os.Stderr, "warn: failed to delete duplicate comment %d: %v\n", c.ID, err)
+				}
+			}
+		}
+	}
+	return updateComment(ctx, client, owner, name, newest.ID, withHistory(newest.Body, body, opts.HistoryLimit))
+}
+
+// appendComment posts body as a new comment, then deletes the oldest Plarix
+// comments so at most keep remain, including the new one. It returns the
+// earlier comments that were kept.
+func appendComment(ctx context.Context, client *http.Client, owner, repo string, prNumber int, body string, existing []ghComment, keep int) ([]ghComment, error) {
+	if err := createComment(ctx, client, owner, repo, prNumber, body); err != nil {
+		return nil, err
+	}
+	if keep <= 0 || len(existing) < keep {
+		return existing, nil
+	}
+	trim := len(existing) - keep + 1
+	for _, c := range existing[:trim] {
+		if err := deleteComment(ctx, client, owner, repo, c.ID); err != nil {
+			fmt.Fprintf(os.Stderr, "warn: failed to delete old comment %d: %v\n", c.ID, err)
+		}
+	}
+	return existing[trim:], nil
+}
+
+// minimizeResolved collapses older comments that reported a budget breach
+// when the latest report no longer does, so stale warnings stop cluttering
+// the conversation.
+func minimizeResolved(ctx context.Context, client *http.Client, older []ghComment, latest string) {
+	if strings.Contains(latest, budgetBreachMarker) {
+		return
+	}
+	for _, c := range older {
+		if c.NodeID == "" || !strings.Contains(c.Body, budgetBreachMarker) {
+			continue
+		}
+		if err := minimizeComment(ctx, client, c.NodeID); err != nil {
+			fmt.Fprintf(os.Stderr, "warn: failed to minimize comment %d: %v\n", c.ID, err)
+		}
+	}
+}
+
+// minimizeComment hides a comment as resolved. Minimizing is only exposed
+// through the GraphQL API.
+func minimizeComment(ctx context.Context, client *http.Client, nodeID string) error {
+	payload := map[string]any{
+		"query":     `mutation($id: ID!) { minimizeComment(input: {subjectId: $id, classifier: RESOLVED}) { minimizedComment { isMinimized } } }`,
+		"variables": map[string]string{"id": nodeID},
+	}
+	buf, _ := json.Marshal(payload)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.github.com/graphql", bytes.NewReader(buf))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return statusError("minimize comment", resp)
 	}
-	if len(s.BeforeMax) > 0 || len(s.AfterMax) > 0 {
-		fmt.Fprintf(b, "- **max_tokens:** %s → %s\n", intsOrDash(s.BeforeMax), intsOrDash(s.AfterMax))
+	// GraphQL reports failures in the body with a 200 status.
+	var result struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
 	}
-	if len(s.BeforeRetry) > 0 || len(s.AfterRetry) > 0 {
-		fmt.Fprintf(b, "- **retries:** %s → %s\n", intsOrDash(s.BeforeRetry), intsOrDash(s.AfterRetry))
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
 	}
-	fmt.Fprintf(b, "\n")
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("minimize comment: %s", result.Errors[0].Message)
+	}
+	return nil
 }
 
-func hasAnySignals(s DiffSignals) bool {
-	return len(s.BeforeModels)+len(s.AfterModels)+len(s.BeforeMax)+len(s.AfterMax)+len(s.BeforeRetry)+len(s.AfterRetry) > 0
-}
+// inlineMarker tags Plarix review comments so reruns skip annotated lines.
+const inlineMarker = "<!-- plarix-inline -->"
 
-func bar(value, max float64) string {
-	width := 22
-	filled := int((value / max) * float64(width))
-	if filled < 1 && value > 0 {
-		filled = 1
+// defaultInlineMax caps the review comments posted per run.
+const defaultInlineMax = 10
+
+// postInlineComments posts a single review with a comment on each signal
+// site that an earlier run has not annotated yet, at most limit of them.
+func postInlineComments(ctx context.Context, client *http.Client, repo string, prNumber int, headSHA string, in reportInput, limit int) error {
+	existing, err := fetchReviewComments(ctx, client, repo, prNumber)
+	if err != nil {
+		return err
 	}
-	if filled > width {
-		filled = width
+	seen := make(map[string]bool)
+	for _, c := range existing {
+		if strings.Contains(c.Body, inlineMarker) {
+			seen[fmt.Sprintf("%s:%d", c.Path, c.Line)] = true
+		}
+	}
+	var comments []map[string]any
+	for _, site := range in.Signals.Sites {
+		if len(comments) >= limit {
+			break
+		}
+		key := fmt.Sprintf("%s:%d", site.File, site.Line)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		comments = append(comments, map[string]any{
+			"path": site.File,
+			"line": site.Line,
+			"side": "RIGHT",
+			"body": inlineMarker + "\n" + siteImpact(in, site),
+		})
+	}
+	if len(comments) == 0 {
+		return nil
 	}
-	return strings.Repeat("█", filled) + strings.Repeat("·", width-filled)
-}
 
-func formatInt(n int) string {
-	if n >= 1_000_000 {
-		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	payload := map[string]any{"event": "COMMENT", "comments": comments}
+	if headSHA != "" {
+		payload["commit_id"] = headSHA
 	}
-	if n >= 1_000 {
-		return fmt.Sprintf("%.1fK", float64(n)/1_000)
+	buf, _ := json.Marshal(payload)
+	url := fmt.Sprintf("https://api.github.com/repos/%s/pulls/%d/reviews", repo, prNumber)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
 	}
-	return strconv.Itoa(n)
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return statusError("create review", resp)
+	}
+	return nil
 }
 
-func mergeModelCounts(a, b map[string]int) []string {
-	seen := make(map[string]bool)
-	var result []string
-	for m := range a {
-		if !seen[m] {
-			seen[m] = true
-			result = append(result, m)
+// siteImpact describes the cost of the model or max_tokens set on one line.
+func siteImpact(in reportInput, site signalSite) string {
+	var lines []string
+	if site.Model != "" {
+		price, found := lookupPrice(in.Pricing, in.Config.Provider, site.Model)
+		switch {
+		case !found:
+			lines = append(lines, fmt.Sprintf("💸 **%s**: no pricing data", site.Model))
+		case in.ConfigFound:
+			est := in.Config
+			est.Provider = price.Provider
+			cost, _ := computeEstimate(est, in.Pricing, price.Name)
+			lines = append(lines, fmt.Sprintf("💸 **%s**: est. %s/request, %s/month at the configured volume", site.Model, formatCost(cost.PerRequest), formatCost(cost.Monthly)))
+		default:
+			lines = append(lines, fmt.Sprintf("💸 **%s**: $%s in / $%s out per 1M tokens", site.Model, roundDecimal(price.InputPerMillion, 2), roundDecimal(price.OutputPerMillion, 2)))
 		}
 	}
-	for m := range b {
-		if !seen[m] {
-			seen[m] = true
-			result = append(result, m)
+	if site.MaxTokens > 0 {
+		model := site.Model
+		if model == "" {
+			model = afterModelFor(in)
+		}
+		if price, found := lookupPrice(in.Pricing, in.Config.Provider, model); found {
+			lines = append(lines, fmt.Sprintf("💸 **max_tokens %s**: worst-case output %s per call at %s rates", formatInt(site.MaxTokens), formatCost(price.Cost(0, site.MaxTokens)), model))
 		}
 	}
-	return result
+	return strings.Join(lines, "\n")
 }
 
-func listOrPlaceholder(values []string) string {
-	if len(values) == 0 {
-		return "—"
+// fetchReviewComments lists the PR's review comments (first 100).
+func fetchReviewComments(ctx context.Context, client *http.Client, repo string, prNumber int) ([]ghReviewComment, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/pulls/%d/comments?per_page=100", repo, prNumber)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
 	}
-	return strings.Join(uniqueStrings(values), ", ")
-}
-
-func intsOrDash(values []int) string {
-	if len(values) == 0 {
-		return "—"
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("github api: %s", resp.Status)
 	}
-	parts := make([]string, 0, len(values))
-	for _, v := range uniqueInts(values) {
-		parts = append(parts, strconv.Itoa(v))
+	var comments []ghReviewComment
+	if err := json.NewDecoder(resp.Body).Decode(&comments); err != nil {
+		return nil, err
 	}
-	return strings.Join(parts, ", ")
+	return comments, nil
 }
 
-func uniqueStrings(in []string) []string {
-	seen := map[string]bool{}
-	var out []string
-	for _, v := range in {
-		if !seen[v] {
-			seen[v] = true
-			out = append(out, v)
-		}
-	}
-	return out
+// reviewMarker tags the review Plarix submits in PLARIX_REVIEW_GATE mode.
+const reviewMarker = "<!-- plarix-review -->"
+
+type ghReview struct {
+	ID    int64  `json:"id"`
+	Body  string `json:"body"`
+	State string `json:"state"` // CHANGES_REQUESTED, COMMENTED, DISMISSED, ...
 }
 
-func uniqueInts(in []int) []int {
-	seen := map[int]bool{}
-	var out []int
-	for _, v := range in {
-		if !seen[v] {
-			seen[v] = true
-			out = append(out, v)
+// gateReview keeps a Plarix PR review in step with the budget gate: a
+// REQUEST_CHANGES review carrying the report while over budget, so branch
+// protection on reviews blocks the merge, and once resolved a dismissal of
+// that review plus a COMMENT saying so. Nothing is posted when the PR was
+// never over budget.
+func gateReview(ctx context.Context, client *http.Client, repo string, prNumber int, headSHA string, breached bool, report string) error {
+	reviews, err := fetchReviews(ctx, client, repo, prNumber)
+	if err != nil {
+		return err
+	}
+	var last *ghReview
+	for i := range reviews {
+		if strings.Contains(reviews[i].Body, reviewMarker) {
+			last = &reviews[i]
 		}
 	}
-	return out
+	blocking := last != nil && last.State == "CHANGES_REQUESTED"
+	base := fmt.Sprintf("https://api.github.com/repos/%s/pulls/%d/reviews", repo, prNumber)
+	switch {
+	case breached && blocking:
+		return reviewCall(ctx, client, http.MethodPut, fmt.Sprintf("%s/%d", base, last.ID), map[string]any{"body": reviewMarker + "\n" + report})
+	case breached:
+		return submitReview(ctx, client, repo, prNumber, headSHA, "REQUEST_CHANGES", reviewMarker+"\n"+report)
+	case blocking:
+		dismiss := map[string]any{"message": "Plarix: cost is back within budget."}
+		if err := reviewCall(ctx, client, http.MethodPut, fmt.Sprintf("%s/%d/dismissals", base, last.ID), dismiss); err != nil {
+			fmt.Fprintf(os.Stderr, "warn: cannot dismiss Plarix review %d: %v\n", last.ID, err)
+		}
+		return submitReview(ctx, client, repo, prNumber, headSHA, "COMMENT", reviewMarker+"\n✅ **Plarix:** cost is back within budget.")
+	}
+	return nil
 }
 
-func safeValue(val, fallback string) string {
-	if strings.TrimSpace(val) == "" {
-		return fallback
+// submitReview creates a PR review with the given event (REQUEST_CHANGES,
+// COMMENT or APPROVE) and body.
+func submitReview(ctx context.Context, client *http.Client, repo string, prNumber int, headSHA, event, body string) error {
+	payload := map[string]any{"event": event, "body": body}
+	if headSHA != "" {
+		payload["commit_id"] = headSHA
 	}
-	return val
+	return reviewCall(ctx, client, http.MethodPost, fmt.Sprintf("https://api.github.com/repos/%s/pulls/%d/reviews", repo, prNumber), payload)
 }
 
-func firstOrDefault(list []string, fallback string) string {
-	if len(list) == 0 {
-		return fallback
+func reviewCall(ctx context.Context, client *http.Client, method, url string, payload map[string]any) error {
+	buf, _ := json.Marshal(payload)
+	req, _ := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(buf))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
 	}
-	return list[0]
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return statusError(strings.ToLower(method)+" review", resp)
+	}
+	return nil
 }
 
-func upsertComment(ctx context.Context, client *http.Client, repo string, prNumber int, body string) error {
-	owner, name, ok := strings.Cut(repo, "/")
-	if !ok {
-		return fmt.Errorf("invalid repo: %s", repo)
-	}
-	existingID, err := findExistingComment(ctx, client, owner, name, prNumber)
+// fetchReviews lists a PR's reviews, oldest first.
+func fetchReviews(ctx context.Context, client *http.Client, repo string, prNumber int) ([]ghReview, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/pulls/%d/reviews?per_page=100", repo, prNumber)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if existingID == 0 {
-		return createComment(ctx, client, owner, name, prNumber, body)
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("github api: %s", resp.Status)
 	}
-	return updateComment(ctx, client, owner, name, existingID, body)
+	var reviews []ghReview
+	if err := json.NewDecoder(resp.Body).Decode(&reviews); err != nil {
+		return nil, err
+	}
+	return reviews, nil
 }
 
-func findExistingComment(ctx context.Context, client *http.Client, owner, repo string, prNumber int) (int64, error) {
+// findExistingComments returns every comment carrying commentMarker, oldest first.
+func findExistingComments(ctx context.Context, client *http.Client, owner, repo string, prNumber int) ([]ghComment, error) {
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/issues/%d/comments?per_page=100", owner, repo, prNumber)
 	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		return 0, fmt.Errorf("github api: %s", resp.Status)
+		return nil, fmt.Errorf("github api: %s", resp.Status)
 	}
 	var comments []ghComment
 	if err := json.NewDecoder(resp.Body).Decode(&comments); err != nil {
-		return 0, err
+		return nil, err
 	}
+	var matches []ghComment
 	for _, c := range comments {
 		if strings.Contains(c.Body, commentMarker) {
-			return c.ID, nil
+			matches = append(matches, c)
 		}
 	}
-	return 0, nil
+	return matches, nil
+}
+
+// withHistory puts latest on top and keeps up to limit earlier analyses from
+// previous below historyDivider, newest first. A rerun that produces the same
+// report does not add an entry. A limit of 0 replaces the comment outright.
+func withHistory(previous, latest string, limit int) string {
+	if limit <= 0 {
+		return latest
+	}
+	prevLatest, prevHistory, _ := strings.Cut(previous, "\n"+historyDivider+"\n")
+
+	var entries []string
+	if strip := stripMarker(prevLatest); strip != stripMarker(latest) {
+		entries = append(entries, strip)
+	}
+	if prevHistory != "" {
+		prevHistory = strings.TrimPrefix(strings.TrimSpace(prevHistory), "<details>")
+		prevHistory = strings.TrimSuffix(prevHistory, "</details>")
+		for _, e := range strings.Split(prevHistory, historyEntry)[1:] {
+			if e = strings.TrimSpace(e); e != "" {
+				entries = append(entries, e)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return latest
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n%s\n\n<details>\n<summary>Previous analyses (%d)</summary>\n\n", strings.TrimRight(latest, "\n"), historyDivider, len(entries))
+	for _, e := range entries {
+		fmt.Fprintf(&b, "%s\n\n%s\n\n", historyEntry, e)
+	}
+	fmt.Fprintf(&b, "</details>\n")
+	return b.String()
+}
+
+func stripMarker(body string) string {
+	return strings.TrimSpace(strings.Replace(body, commentMarker, "", 1))
+}
+
+func createCommitStatus(ctx context.Context, client *http.Client, repo, sha, state, description string) error {
+	payload := map[string]string{
+		"state":       state,
+		"context":     "plarix/cost",
+		"description": truncate(description, 140),
+	}
+	buf, _ := json.Marshal(payload)
+	url := fmt.Sprintf("https://api.github.com/repos/%s/statuses/%s", repo, sha)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("create status: %s", resp.Status)
+	}
+	return nil
+}
+
+const (
+	truncatedNote   = "\n\n_✂️ Report truncated to fit GitHub's comment size limit; the full report is in the job summary._\n"
+	removedSection  = "_✂️ Collapsed section removed to fit GitHub's comment size limit._"
+	detailsOpenTag  = "<details"
+	detailsCloseTag = "</details>"
+)
+
+// fitComment shrinks body to maxCommentChars, least important content
+// first: the comment history, then the largest collapsed sections (verbose
+// breakdowns), then everything past a line near the limit. Cuts keep the
+// start of the body, so the marker and headline always survive.
+func fitComment(body string) string {
+	fits := func(s string) bool { return utf8.RuneCountInString(s) <= maxCommentChars }
+	if fits(body) {
+		return body
+	}
+	body, _, _ = strings.Cut(body, "\n"+historyDivider+"\n")
+	for !fits(body + truncatedNote) {
+		start, end := largestDetails(body)
+		if start < 0 {
+			break
+		}
+		body = body[:start] + removedSection + body[end:]
+	}
+	if !fits(body + truncatedNote) {
+		runes := []rune(body)
+		body = string(runes[:maxCommentChars-utf8.RuneCountInString(truncatedNote)])
+		if i := strings.LastIndex(body, "\n"); i > 0 {
+			body = body[:i]
+		}
+	}
+	fmt.Fprintf(os.Stderr, "warn: report exceeds GitHub's %d-character comment limit; the PR comment is truncated\n", maxCommentChars)
+	return body + truncatedNote
+}
+
+// largestDetails returns the byte span of the longest <details> block that
+// contains no other one, or -1 when there is none. Innermost blocks are
+// removed first so a wrapper such as the draft collapse keeps its content.
+func largestDetails(body string) (int, int) {
+	bestStart, bestEnd := -1, -1
+	for offset := 0; ; {
+		i := strings.Index(body[offset:], detailsOpenTag)
+		if i < 0 {
+			break
+		}
+		start := offset + i
+		offset = start + len(detailsOpenTag)
+		j := strings.Index(body[offset:], detailsCloseTag)
+		if j < 0 {
+			break
+		}
+		end := offset + j + len(detailsCloseTag)
+		if strings.Contains(body[offset:end], detailsOpenTag) {
+			continue
+		}
+		if end-start > bestEnd-bestStart {
+			bestStart, bestEnd = start, end
+		}
+	}
+	return bestStart, bestEnd
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
 }
 
 func createComment(ctx context.Context, client *http.Client, owner, repo string, prNumber int, body string) error {
-	payload := map[string]string{"body": body}
+	payload := map[string]string{"body": fitComment(body)}
 	buf, _ := json.Marshal(payload)
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/issues/%d/comments", owner, repo, prNumber)
 	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
@@ -831,13 +5680,13 @@ func createComment(ctx context.Context, client *http.Client, owner, repo string,
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
-		return fmt.Errorf("create comment: %s", resp.Status)
+		return statusError("create comment", resp)
 	}
 	return nil
 }
 
 func updateComment(ctx context.Context, client *http.Client, owner, repo string, id int64, body string) error {
-	payload := map[string]string{"body": body}
+	payload := map[string]string{"body": fitComment(body)}
 	buf, _ := json.Marshal(payload)
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/issues/comments/%d", owner, repo, id)
 	req, _ := http.NewRequestWithContext(ctx, http.MethodPatch, url, bytes.NewReader(buf))
@@ -847,11 +5696,202 @@ func updateComment(ctx context.Context, client *http.Client, owner, repo string,
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
-		return fmt.Errorf("update comment: %s", resp.Status)
+		return statusError("update comment", resp)
+	}
+	return nil
+}
+
+func deleteComment(ctx context.Context, client *http.Client, owner, repo string, id int64) error {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/issues/comments/%d", owner, repo, id)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return statusError("delete comment", resp)
 	}
 	return nil
 }
 
+// attributionRecord is one PLARIX_ATTRIBUTION_LOG line: who opened the PR
+// and the cost delta the report computed, for org-wide leaderboards.
+type attributionRecord struct {
+	Timestamp string      `json:"timestamp"`
+	Repo      string      `json:"repo"`
+	PR        int         `json:"pr"`
+	Author    string      `json:"author"`
+	HeadSHA   string      `json:"head_sha,omitempty"`
+	Basis     string      `json:"basis"` // "measured" or "est. monthly"
+	Before    json.Number `json:"before_usd"`
+	After     json.Number `json:"after_usd"`
+	Delta     json.Number `json:"delta_usd"`
+}
+
+// appendAttribution appends the PR's author and cost delta to path as a
+// JSONL line. Reports without a Before/After pair write nothing.
+func appendAttribution(path string, in reportInput, repo string, prNumber int, eventPath string) error {
+	before, after, basis, ok := costDelta(in)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "plarix: no cost delta to attribute, skipping %s\n", path)
+		return nil
+	}
+	if prNumber == 0 {
+		// Offline runs skip the API but may still have an event payload.
+		prNumber, _ = readPRNumber(eventPath)
+	}
+	_, headSHA := readPRShas(eventPath)
+	line, err := json.Marshal(attributionRecord{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Repo:      repo,
+		PR:        prNumber,
+		Author:    readPRAuthor(eventPath),
+		HeadSHA:   headSHA,
+		Basis:     strings.ToLower(basis),
+		Before:    json.Number(roundDecimal(before, 4)),
+		After:     json.Number(roundDecimal(after, 4)),
+		Delta:     json.Number(roundDecimal(after-before, 4)),
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// promMetrics renders the report's cost figures in the Prometheus text
+// exposition format for PLARIX_METRICS_FILE. Measured logs give run costs
+// (plarix_measured_cost); otherwise .plarix.yml gives monthly estimates
+// (plarix_monthly_cost). ref is "base" (Before) or "head" (After).
+func promMetrics(in reportInput) string {
+	var b strings.Builder
+	metric := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	sample := func(name string, value float64, labels ...string) {
+		var pairs []string
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], promEscaper.Replace(labels[i+1])))
+		}
+		// Micro-dollar precision hides float noise such as 5.8500000000000005.
+		fmt.Fprintf(&b, "%s{%s} %s\n", name, strings.Join(pairs, ","), strconv.FormatFloat(math.Round(value*1e6)/1e6, 'f', -1, 64))
+	}
+
+	metric("plarix_data_source", "Where the report's numbers come from; always 1.")
+	sample("plarix_data_source", 1, "mode", dataSourceOf(in))
+
+	sides := []struct {
+		ref string
+		m   *MeasuredSummary
+	}{{"base", in.BaseMeasured}, {"head", in.HeadMeasured}}
+	switch {
+	case in.BaseMeasured != nil || in.HeadMeasured != nil:
+		metric("plarix_measured_cost", "Measured cost of the logged calls in USD.")
+		for _, side := range sides {
+			if side.m != nil {
+				sample("plarix_measured_cost", side.m.TotalCost, "ref", side.ref)
+			}
+		}
+		metric("plarix_measured_calls", "Calls in the measured log.")
+		for _, side := range sides {
+			if side.m != nil {
+				sample("plarix_measured_calls", float64(side.m.CallCount), "ref", side.ref)
+			}
+		}
+		metric("plarix_model_cost", "Measured cost per model in USD.")
+		for _, side := range sides {
+			if side.m == nil {
+				continue
+			}
+			for _, key := range sortedKeys(side.m.ModelCosts) {
+				provider, model := splitMeasuredKey(key)
+				sample("plarix_model_cost", side.m.ModelCosts[key], "ref", side.ref, "provider", provider, "model", model)
+			}
+		}
+	case in.ConfigFound:
+		beforeModel, afterModel := beforeModelFor(in), afterModelFor(in)
+		before, _ := beforeEstimate(in, beforeAssumptions(in))
+		after, _ := afterEstimate(in, afterAssumptions(in))
+		metric("plarix_monthly_cost", "Estimated monthly cost in USD from .plarix.yml.")
+		sample("plarix_monthly_cost", before.Monthly, "ref", "base")
+		sample("plarix_monthly_cost", after.Monthly, "ref", "head")
+		metric("plarix_model_cost", "Estimated monthly cost of the priced model in USD.")
+		sample("plarix_model_cost", before.Monthly, "ref", "base", "provider", beforeAssumptions(in).Provider, "model", beforeModel)
+		sample("plarix_model_cost", after.Monthly, "ref", "head", "provider", afterAssumptions(in).Provider, "model", afterModel)
+	}
+	if before, after, basis, ok := costDelta(in); ok {
+		metric("plarix_cost_delta", "After minus Before cost in USD.")
+		if basis != "Measured" {
+			basis = "monthly"
+		}
+		sample("plarix_cost_delta", after-before, "basis", strings.ToLower(basis))
+	}
+	return b.String()
+}
+
+// promEscaper escapes label values as the exposition format requires.
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeArtifact writes a report or metrics file to path for upload as a
+// build artifact, creating parent directories as needed.
+func writeArtifact(path, report string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, []byte(report), 0o644)
+}
+
+// writeOutputs appends step outputs to GITHUB_OUTPUT, in key order so reruns
+// write identical files. Outside Actions it does nothing.
+func writeOutputs(outputs map[string]string) error {
+	path := os.Getenv("GITHUB_OUTPUT")
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(outputs) {
+		if _, err := fmt.Fprintf(f, "%s=%s\n", k, outputs[k]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// envBool reports whether an environment variable is set to a true value.
+func envBool(name string) bool {
+	v, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv(name)))
+	return v
+}
+
+// envInt reads a non-negative integer from the environment, returning
+// fallback when the variable is unset or invalid.
+func envInt(name string, fallback int) int {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return fallback
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		fmt.Fprintf(os.Stderr, "warn: ignoring invalid %s=%q\n", name, raw)
+		return fallback
+	}
+	return v
+}
+
 func fatalf(format string, args ...any) {
 	fmt.Fprintf(os.Stderr, format+"\n", args...)
 	os.Exit(1)
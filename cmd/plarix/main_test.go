@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// testPricing is a small pricing table shared by the tests.
+var testPricing = PricingFile{
+	LastUpdated: "2026-10-01",
+	Models: []ModelPrice{
+		{Provider: "openai", Name: "gpt-4o", InputPerMillion: 2.5, OutputPerMillion: 10},
+		{Provider: "openai", Name: "gpt-4o-mini", InputPerMillion: 0.15, OutputPerMillion: 0.6},
+	},
+}
+
+func TestParseConfigLineEndings(t *testing.T) {
+	const config = "assumptions:\n  requests_per_day: 1000\n  avg_input_tokens: 500\n  avg_output_tokens: 200\n  provider: openai\n  model: gpt-4o\n"
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"LF", config},
+		{"CRLF", strings.ReplaceAll(config, "\n", "\r\n")},
+		{"BOM", "\ufeff" + config},
+		{"BOM and CRLF", "\ufeff" + strings.ReplaceAll(config, "\n", "\r\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			if issues := parseConfig(strings.NewReader(tt.input), &cfg); len(issues) > 0 {
+				t.Fatalf("unexpected issues: %v", issues)
+			}
+			a := cfg.Assumptions
+			if a.RequestsPerDay != 1000 || a.AvgInputTokens != 500 || a.AvgOutputTokens != 200 {
+				t.Errorf("got %v req/day, %d in, %d out; want 1000, 500, 200", a.RequestsPerDay, a.AvgInputTokens, a.AvgOutputTokens)
+			}
+			if a.Provider != "openai" || a.Model != "gpt-4o" {
+				t.Errorf("got provider %q model %q; want openai gpt-4o", a.Provider, a.Model)
+			}
+		})
+	}
+}
+
+func TestParseMeasuredUsageLineEndings(t *testing.T) {
+	const log = `{"provider": "openai", "model": "gpt-4o", "input_tokens": 1000, "output_tokens": 100}` + "\n" +
+		`{"provider": "openai", "model": "gpt-4o", "input_tokens": 3000, "output_tokens": 300}` + "\n"
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"LF", log},
+		{"CRLF", strings.ReplaceAll(log, "\n", "\r\n")},
+		{"BOM", "\ufeff" + log},
+		{"BOM and CRLF", "\ufeff" + strings.ReplaceAll(log, "\n", "\r\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := parseMeasuredUsage(strings.NewReader(tt.input), "test.jsonl", testPricing, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.CallCount != 2 || m.SkippedLines != 0 {
+				t.Errorf("got %d calls, %d skipped; want 2, 0", m.CallCount, m.SkippedLines)
+			}
+			if m.TotalInputTokens != 4000 || m.TotalOutputTokens != 400 {
+				t.Errorf("got %d in, %d out; want 4000, 400", m.TotalInputTokens, m.TotalOutputTokens)
+			}
+		})
+	}
+}
+
+func TestParseMeasuredUsageOversizedLine(t *testing.T) {
+	defer func(limit int) { maxMeasuredLine = limit }(maxMeasuredLine)
+	maxMeasuredLine = 1 << 20
+
+	call := `{"provider": "openai", "model": "gpt-4o", "input_tokens": 1000, "output_tokens": 100}`
+	huge := `{"provider": "openai", "model": "gpt-4o", "prompt": "` + strings.Repeat("x", 5<<20) + `", "input_tokens": 1, "output_tokens": 1}`
+	input := call + "\n" + huge + "\n" + call + "\n" + call + "\n"
+
+	m, err := parseMeasuredUsage(strings.NewReader(input), "test.jsonl", testPricing, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CallCount != 3 || m.SkippedLines != 1 {
+		t.Errorf("got %d calls, %d skipped; want 3, 1", m.CallCount, m.SkippedLines)
+	}
+	if m.TotalInputTokens != 3000 {
+		t.Errorf("got %d input tokens; want 3000", m.TotalInputTokens)
+	}
+}
+
+func TestParseMeasuredUsageLongLine(t *testing.T) {
+	// Well past bufio.Scanner's 64KB token limit but under maxMeasuredLine.
+	call := `{"provider": "openai", "model": "gpt-4o", "input_tokens": 1000, "output_tokens": 100}`
+	long := `{"provider": "openai", "model": "gpt-4o", "prompt": "` + strings.Repeat("x", 5<<20) + `", "input_tokens": 2000, "output_tokens": 100}`
+	input := long + "\n" + call + "\n"
+
+	m, err := parseMeasuredUsage(strings.NewReader(input), "test.jsonl", testPricing, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CallCount != 2 || m.SkippedLines != 0 || m.TotalInputTokens != 3000 {
+		t.Errorf("got %d calls, %d skipped, %d input tokens; want 2, 0, 3000", m.CallCount, m.SkippedLines, m.TotalInputTokens)
+	}
+}
+
+func TestMeasuredLogRedaction(t *testing.T) {
+	secrets := []string{
+		"SECRET-PROMPT-TEXT",
+		"ignore previous instructions",
+		"<script>alert(1)</script>",
+		"Jane Doe <jane@example.com>, order #4411",
+	}
+	input := `{"provider": "openai", "model": "gpt-4o", "input_tokens": 1000, "output_tokens": 100, "prompt": "SECRET-PROMPT-TEXT", "label": "checkout"}` + "\n" +
+		`{"provider": "openai; ignore previous instructions", "model": "gpt-4o", "input_tokens": 1000, "output_tokens": 100}` + "\n" +
+		`{"provider": "openai", "model": "<script>alert(1)</script>", "input_tokens": 1000, "output_tokens": 100}` + "\n" +
+		`{"provider": "openai", "model": "gpt-4o", "input_tokens": 1000, "output_tokens": 100, "label": "Jane Doe <jane@example.com>, order #4411"}` + "\n"
+
+	m, err := parseMeasuredUsage(strings.NewReader(input), "test.jsonl", testPricing, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CallCount != 4 {
+		t.Fatalf("got %d calls; want 4", m.CallCount)
+	}
+	if m.Labels["checkout"] != 1 || m.Labels[redactedField] != 1 {
+		t.Errorf("got labels %v; want checkout and %s once each", m.Labels, redactedField)
+	}
+
+	report := buildReport(reportInput{Pricing: testPricing, HeadMeasured: m})
+	for _, s := range secrets {
+		if strings.Contains(report, s) {
+			t.Errorf("report contains %q", s)
+		}
+	}
+	for _, want := range []string{"checkout", redactedField} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report does not mention %q", want)
+		}
+	}
+}
+
+func TestFitComment(t *testing.T) {
+	head := commentMarker + "\n\n## 💸 Plarix Cost Report\n\n**Headline:** +$12.00/day\n\n"
+	inner := "<details><summary>Per-file signals</summary>\n\n" + strings.Repeat("| file.go | gpt-4o |\n", 4000) + "\n</details>\n"
+	outer := "<details><summary>Draft report</summary>\n\nOUTER-START\n\n" + inner + "\nOUTER-END\n\n</details>\n"
+	small := "<details><summary>Pricing</summary>\n\nSMALL-SECTION\n\n</details>\n"
+
+	tests := []struct {
+		name      string
+		body      string
+		keep      []string
+		drop      []string
+		sectioned bool
+	}{
+		{
+			name:      "inner details removed first",
+			body:      head + outer + small,
+			keep:      []string{"OUTER-START", "OUTER-END", "SMALL-SECTION"},
+			drop:      []string{"Per-file signals"},
+			sectioned: true,
+		},
+		{
+			name: "history dropped",
+			body: head + small + "\n" + historyDivider + "\n" + strings.Repeat("old run ✓\n", 8000),
+			keep: []string{"SMALL-SECTION"},
+			drop: []string{"old run"},
+		},
+		{
+			name: "cut at a line",
+			body: head + strings.Repeat("| gpt-4o | 1,000 | $0.01 ✓ |\n", 4000),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if n := utf8.RuneCountInString(tt.body); n <= maxCommentChars {
+				t.Fatalf("test body is only %d characters", n)
+			}
+			got := fitComment(tt.body)
+			if n := utf8.RuneCountInString(got); n > maxCommentChars {
+				t.Errorf("got %d characters; want at most %d", n, maxCommentChars)
+			}
+			if !strings.HasPrefix(got, head) {
+				t.Errorf("marker and headline did not survive: %.80q", got)
+			}
+			if !strings.HasSuffix(got, truncatedNote) {
+				t.Errorf("truncated note not appended")
+			}
+			if strings.Contains(got, removedSection) != tt.sectioned {
+				t.Errorf("removed-section note present = %v; want %v", !tt.sectioned, tt.sectioned)
+			}
+			for _, s := range tt.keep {
+				if !strings.Contains(got, s) {
+					t.Errorf("%q was removed", s)
+				}
+			}
+			for _, s := range tt.drop {
+				if strings.Contains(got, s) {
+					t.Errorf("%q was kept", s)
+				}
+			}
+		})
+	}
+
+	if short := head + small; fitComment(short) != short {
+		t.Errorf("body under the limit was changed")
+	}
+}
+
+func TestWriteSuggestionsSameFamily(t *testing.T) {
+	pricing := PricingFile{Models: []ModelPrice{
+		{Provider: "openai", Name: "gpt-4-turbo", InputPerMillion: 10, OutputPerMillion: 30},
+		{Provider: "openai", Name: "gpt-4o", InputPerMillion: 2.5, OutputPerMillion: 10},
+		{Provider: "openai", Name: "gpt-4o-mini", InputPerMillion: 0.15, OutputPerMillion: 0.6},
+		{Provider: "openai", Name: "o1", InputPerMillion: 15, OutputPerMillion: 60},
+		{Provider: "openai", Name: "o3", InputPerMillion: 2, OutputPerMillion: 8},
+		{Provider: "anthropic", Name: "claude-opus-4", InputPerMillion: 15, OutputPerMillion: 75},
+		{Provider: "anthropic", Name: "claude-3-haiku", InputPerMillion: 0.25, OutputPerMillion: 1.25},
+	}}
+	tests := []struct {
+		model    string
+		families map[string]string
+		want     string // suggested model, "" for none
+	}{
+		{model: "gpt-4-turbo", want: "gpt-4o"},
+		{model: "o1", want: "o3"},
+		{model: "o3"},
+		{model: "gpt-4o"},
+		{model: "claude-opus-4"},
+		{model: "gpt-4o", families: map[string]string{"gpt-4o*": "gpt-4o"}, want: "gpt-4o-mini"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			var b strings.Builder
+			writeSuggestions(&b, reportInput{
+				Pricing:  pricing,
+				Signals:  DiffSignals{AfterModels: []string{tt.model}},
+				Families: tt.families,
+			})
+			got := ""
+			if _, rest, ok := strings.Cut(b.String(), "Consider `"); ok {
+				got, _, _ = strings.Cut(rest, "`")
+			}
+			if got != tt.want {
+				t.Errorf("suggested %q for %s; want %q", got, tt.model, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxTokenOverrunsNearestModel(t *testing.T) {
+	pricing := PricingFile{Models: []ModelPrice{
+		{Provider: "openai", Name: "gpt-4o-mini", InputPerMillion: 0.15, OutputPerMillion: 0.6, MaxOutputTokens: 16384},
+		{Provider: "anthropic", Name: "claude-3-7-sonnet", InputPerMillion: 3, OutputPerMillion: 15, MaxOutputTokens: 64000},
+	}}
+	patch := `@@ -1,6 +1,12 @@
+ def summarize(text):
+     return openai.chat.completions.create(
+         model="gpt-4o-mini",
+-        max_tokens=1024,
++        max_tokens=8192,
+         messages=[{"role": "user", "content": text}],
+     )
+ 
++def draft(text):
++    return anthropic.messages.create(
++        model="claude-3-7-sonnet",
++        max_tokens=50000,
++        messages=[{"role": "user", "content": text}],
++    )
+`
+	s := extractSignals([]ghFile{{Filename: "app/llm.py", Patch: patch}}, FileFilter{})
+
+	pairs := map[int]string{}
+	for _, p := range s.MaxTokenPairs {
+		pairs[p.MaxTokens] = p.Model
+	}
+	want := map[int]string{8192: "gpt-4o-mini", 50000: "claude-3-7-sonnet"}
+	if len(s.MaxTokenPairs) != len(want) {
+		t.Errorf("got pairs %v; want %v", s.MaxTokenPairs, want)
+	}
+	for tokens, model := range want {
+		if pairs[tokens] != model {
+			t.Errorf("max_tokens %d paired with %q; want %q", tokens, pairs[tokens], model)
+		}
+	}
+	if got := maxTokenOverruns(s, pricing); len(got) != 0 {
+		t.Errorf("unexpected overrun warnings: %v", got)
+	}
+
+	over := strings.Replace(patch, "max_tokens=8192", "max_tokens=32000", 1)
+	s = extractSignals([]ghFile{{Filename: "app/llm.py", Patch: over}}, FileFilter{})
+	if got := maxTokenOverruns(s, pricing); len(got) != 1 || !strings.Contains(got[0], "gpt-4o-mini") {
+		t.Errorf("got overruns %v; want one for gpt-4o-mini", got)
+	}
+
+	// An over-limit value the PR leaves unchanged (only the rest of its line
+	// is edited) is not the PR's doing.
+	unchanged := `@@ -1,4 +1,4 @@
+ def summarize(text):
+     return openai.chat.completions.create(
+         model="gpt-4o-mini",
+-        max_tokens=32000, messages=[{"role": "user", "content": text}],
++        max_tokens=32000, messages=[{"role": "user", "content": text.strip()}],
+     )
+`
+	s = extractSignals([]ghFile{{Filename: "app/llm.py", Patch: unchanged}}, FileFilter{})
+	if len(s.MaxTokenPairs) != 0 {
+		t.Errorf("got pairs %v for an unchanged max_tokens; want none", s.MaxTokenPairs)
+	}
+	if got := maxTokenOverruns(s, pricing); len(got) != 0 {
+		t.Errorf("got overruns %v for an unchanged max_tokens; want none", got)
+	}
+}
+
+// roundTripFunc stubs the GitHub API for client tests.
+type roundTripFunc func(*http.Request) *http.Response
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req), nil }
+
+func TestReadOnlyTokenNotedInSummary(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) *http.Response {
+		// Reads succeed with a read-only token; only the write is refused.
+		if req.Method == http.MethodGet {
+			return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader("[]"))}
+		}
+		return &http.Response{StatusCode: http.StatusForbidden, Status: "403 Forbidden", Body: io.NopCloser(strings.NewReader("{}"))}
+	})}
+	err := upsertComment(context.Background(), client, "acme/app", 7, commentMarker+"\n\nreport", commentOptions{})
+	if !errors.Is(err, errNoPermission) {
+		t.Fatalf("got %v; want errNoPermission", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "summary.md")
+	if err := os.WriteFile(path, []byte("report\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GITHUB_STEP_SUMMARY", path)
+	appendSummary(commentSkippedNote("the token lacks permission to write PR comments"))
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "report\n\n_ℹ️ PR comment skipped: the token lacks permission to write PR comments._\n"; string(got) != want {
+		t.Errorf("summary = %q; want %q", got, want)
+	}
+}
+
+func TestParseConfigProviderDefault(t *testing.T) {
+	tests := []struct {
+		name, input  string
+		wantProvider string
+	}{
+		{"neither set", "assumptions:\n  requests_per_day: 10\n", "openai"},
+		{"model only", "assumptions:\n  model: claude-3-5-sonnet\n", ""},
+		{"provider after model", "assumptions:\n  model: claude-3-5-sonnet\n  provider: anthropic\n", "anthropic"},
+		{"provider before model", "assumptions:\n  provider: anthropic\n  model: claude-3-5-sonnet\n", "anthropic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			parseConfig(strings.NewReader(tt.input), &cfg)
+			if got := cfg.Assumptions.Provider; got != tt.wantProvider {
+				t.Errorf("provider = %q; want %q", got, tt.wantProvider)
+			}
+		})
+	}
+}
+
+func TestInferProviderWarnsOncePerPricing(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		p := PricingFile{Models: testPricing.Models, providerWarned: map[string]bool{}}
+		if !p.warnOnce("gpt-4o") {
+			t.Fatalf("pricing %d: first warning suppressed", i)
+		}
+		if p.warnOnce("gpt-4o") {
+			t.Errorf("pricing %d: second warning not suppressed", i)
+		}
+		if price, found := inferProvider(p, "gpt-4o"); !found || price.Provider != "openai" {
+			t.Errorf("pricing %d: inferred %+v, %v; want openai", i, price, found)
+		}
+	}
+}
+
+func TestWriteConfigDrift(t *testing.T) {
+	log := `{"provider": "anthropic", "model": "claude-3-5-sonnet", "input_tokens": 1000, "output_tokens": 100}` + "\n"
+	m, err := parseMeasuredUsage(strings.NewReader(log), "test.jsonl", testPricing, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, config string
+		want         []string
+	}{
+		{"defaults only", "assumptions:\n  requests_per_day: 10\n", nil},
+		{"model set", "assumptions:\n  model: gpt-4o\n", []string{"sets provider `openai`", "sets model `gpt-4o`"}},
+		{"provider set", "assumptions:\n  provider: openai\n", []string{"sets provider `openai`"}},
+		{"matching", "assumptions:\n  provider: anthropic\n  model: claude-3-5-sonnet\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			parseConfig(strings.NewReader(tt.config), &cfg)
+			var b strings.Builder
+			writeConfigDrift(&b, cfg.Assumptions, testPricing, m)
+			if got := strings.Count(b.String(), "Config drift"); got != len(tt.want) {
+				t.Errorf("got %d drift warnings in %q; want %d", got, b.String(), len(tt.want))
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(b.String(), w) {
+					t.Errorf("missing %q in %q", w, b.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCacheSavingsIgnoreFreeTokens(t *testing.T) {
+	pricing := PricingFile{
+		Models: []ModelPrice{
+			{Provider: "openai", Name: "gpt-4o", InputPerMillion: 2.5, OutputPerMillion: 10, CachedInputPerMillion: 1.25},
+		},
+		FreeTokens: map[string]int{"gpt-4o": 2_000_000},
+	}
+	log := `{"provider": "openai", "model": "gpt-4o", "input_tokens": 1000000, "output_tokens": 0}` + "\n" +
+		`{"provider": "openai", "model": "gpt-4o", "input_tokens": 1000000, "cached_input_tokens": 1000000, "output_tokens": 0}` + "\n"
+	m, err := parseMeasuredUsage(strings.NewReader(log), "test.jsonl", pricing, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.TotalCost != 0 {
+		t.Errorf("total cost = %v; want 0 under the free allowance", m.TotalCost)
+	}
+	// Only the cached call saves: 1M tokens at $2.50 instead of $1.25.
+	if m.CacheSavings != 1.25 {
+		t.Errorf("cache savings = %v; want 1.25", m.CacheSavings)
+	}
+}
@@ -2,42 +2,149 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"time"
 )
 
+// pricingFile mirrors the subset of pricing.json needed to diff two versions.
+type pricingFile struct {
+	LastUpdated string       `json:"last_updated"`
+	Models      []modelPrice `json:"models"`
+}
+
+type modelPrice struct {
+	Provider              string     `json:"provider"`
+	Name                  string     `json:"name"`
+	InputPerMillion       float64    `json:"input_per_million"`
+	OutputPerMillion      float64    `json:"output_per_million"`
+	CachedInputPerMillion float64    `json:"cached_input_per_million,omitempty"`
+	NamePattern           string     `json:"name_pattern,omitempty"`
+	Tier                  *priceTier `json:"tier,omitempty"`
+	MinChargePerCall      float64    `json:"min_charge_per_call,omitempty"`
+}
+
+type priceTier struct {
+	AboveInputTokens int     `json:"above_input_tokens"`
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
+func (t *priceTier) String() string {
+	if t == nil {
+		return "none"
+	}
+	return fmt.Sprintf(">%d input: $%s in / $%s out", t.AboveInputTokens, rate(t.InputPerMillion), rate(t.OutputPerMillion))
+}
+
 // This tool rewrites pricing.json from the table below.
 // After updating prices from official sources, run: go run ./cmd/update-pricing
+// Pass -changes PRICING_CHANGES.md to also write the rate diff as markdown.
 func main() {
+	changesPath := flag.String("changes", "", "optional path to write a markdown changelog of rate changes")
+	flag.Parse()
+
+	// Load the current file before overwriting so the diff can be reported.
+	var previous pricingFile
+	if existing, err := os.ReadFile("pricing.json"); err == nil {
+		if err := json.Unmarshal(existing, &previous); err != nil {
+			fmt.Fprintf(os.Stderr, "warn: cannot parse existing pricing.json: %v\n", err)
+		}
+	}
+
 	pricing := map[string]any{
 		"last_updated": time.Now().Format("2006-01-02"),
 		"sources": []string{
 			"https://platform.openai.com/docs/pricing",
 			"https://claude.com/platform/api",
+			"https://ai.google.dev/pricing",
 		},
+		// name_pattern globs map dated snapshots (e.g. claude-3-5-sonnet-20241022)
+		// onto the base row so new snapshots need no pricing change.
 		"models": []map[string]any{
 			// OpenAI models (verified Dec 2024 from platform.openai.com/docs/pricing)
-			{"provider": "openai", "name": "gpt-4o", "input_per_million": 2.50, "output_per_million": 10.0},
-			{"provider": "openai", "name": "gpt-4o-mini", "input_per_million": 0.15, "output_per_million": 0.60},
-			{"provider": "openai", "name": "gpt-4-turbo", "input_per_million": 10.0, "output_per_million": 30.0},
+			{"provider": "openai", "name": "gpt-4o", "name_pattern": "gpt-4o-20*", "input_per_million": 2.50, "output_per_million": 10.0, "cached_input_per_million": 1.25},
+			{"provider": "openai", "name": "gpt-4o-mini", "name_pattern": "gpt-4o-mini-20*", "input_per_million": 0.15, "output_per_million": 0.60, "cached_input_per_million": 0.075},
+			{"provider": "openai", "name": "gpt-4-turbo", "name_pattern": "gpt-4-turbo-20*", "input_per_million": 10.0, "output_per_million": 30.0},
 			{"provider": "openai", "name": "gpt-3.5-turbo", "input_per_million": 0.50, "output_per_million": 1.50},
-			{"provider": "openai", "name": "o1", "input_per_million": 15.0, "output_per_million": 60.0},
-			{"provider": "openai", "name": "o1-mini", "input_per_million": 1.10, "output_per_million": 4.40},
-			{"provider": "openai", "name": "o3", "input_per_million": 2.0, "output_per_million": 8.0},
-			{"provider": "openai", "name": "o3-mini", "input_per_million": 1.10, "output_per_million": 4.40},
-			{"provider": "openai", "name": "o4-mini", "input_per_million": 1.10, "output_per_million": 4.40},
+			{"provider": "openai", "name": "o1", "name_pattern": "o1-20*", "input_per_million": 15.0, "output_per_million": 60.0, "cached_input_per_million": 7.5},
+			{"provider": "openai", "name": "o1-mini", "input_per_million": 1.10, "output_per_million": 4.40, "cached_input_per_million": 0.55},
+			{"provider": "openai", "name": "o3", "name_pattern": "o3-20*", "input_per_million": 2.0, "output_per_million": 8.0, "cached_input_per_million": 0.5},
+			{"provider": "openai", "name": "o3-mini", "name_pattern": "o3-mini-20*", "input_per_million": 1.10, "output_per_million": 4.40, "cached_input_per_million": 0.55},
+			{"provider": "openai", "name": "o4-mini", "name_pattern": "o4-mini-20*", "input_per_million": 1.10, "output_per_million": 4.40, "cached_input_per_million": 0.275},
 			// Anthropic models (verified Dec 2024 from claude.com/platform/api)
-			{"provider": "anthropic", "name": "claude-sonnet-4", "input_per_million": 3.0, "output_per_million": 15.0},
-			{"provider": "anthropic", "name": "claude-3-5-sonnet", "input_per_million": 3.0, "output_per_million": 15.0},
-			{"provider": "anthropic", "name": "claude-3-5-sonnet-latest", "input_per_million": 3.0, "output_per_million": 15.0},
-			{"provider": "anthropic", "name": "claude-haiku-4", "input_per_million": 1.0, "output_per_million": 5.0},
-			{"provider": "anthropic", "name": "claude-3-5-haiku", "input_per_million": 1.0, "output_per_million": 5.0},
-			{"provider": "anthropic", "name": "claude-opus-4", "input_per_million": 5.0, "output_per_million": 25.0},
-			{"provider": "anthropic", "name": "claude-3-opus", "input_per_million": 15.0, "output_per_million": 75.0},
+			// Prompts over 200K input tokens are billed at the long-context rate.
+			{"provider": "anthropic", "name": "claude-sonnet-4", "name_pattern": "claude-sonnet-4-20*", "input_per_million": 3.0, "output_per_million": 15.0, "cached_input_per_million": 0.3,
+				"tier": map[string]any{"above_input_tokens": 200_000, "input_per_million": 6.0, "output_per_million": 22.50}},
+			{"provider": "anthropic", "name": "claude-3-5-sonnet", "name_pattern": "claude-3-5-sonnet-20*", "input_per_million": 3.0, "output_per_million": 15.0, "cached_input_per_million": 0.3},
+			{"provider": "anthropic", "name": "claude-3-5-sonnet-latest", "input_per_million": 3.0, "output_per_million": 15.0, "cached_input_per_million": 0.3},
+			{"provider": "anthropic", "name": "claude-haiku-4", "name_pattern": "claude-haiku-4-20*", "input_per_million": 1.0, "output_per_million": 5.0, "cached_input_per_million": 0.1},
+			{"provider": "anthropic", "name": "claude-3-5-haiku", "name_pattern": "claude-3-5-haiku-20*", "input_per_million": 1.0, "output_per_million": 5.0, "cached_input_per_million": 0.1},
+			{"provider": "anthropic", "name": "claude-opus-4", "name_pattern": "claude-opus-4-20*", "input_per_million": 5.0, "output_per_million": 25.0, "cached_input_per_million": 0.5},
+			{"provider": "anthropic", "name": "claude-3-opus", "name_pattern": "claude-3-opus-20*", "input_per_million": 15.0, "output_per_million": 75.0, "cached_input_per_million": 1.5},
+			// Google models (ai.google.dev/pricing); prompts over 128K input tokens use the higher tier.
+			{"provider": "google", "name": "gemini-1.5-pro", "input_per_million": 1.25, "output_per_million": 5.0,
+				"tier": map[string]any{"above_input_tokens": 128_000, "input_per_million": 2.50, "output_per_million": 10.0}},
+			{"provider": "google", "name": "gemini-1.5-flash", "input_per_million": 0.075, "output_per_million": 0.30,
+				"tier": map[string]any{"above_input_tokens": 128_000, "input_per_million": 0.15, "output_per_million": 0.60}},
 		},
 	}
 
+	// Average characters per token by tokenizer family, for estimating tokens
+	// from raw text. Claude's tokenizer splits English slightly finer.
+	charsPerToken := map[string]float64{"openai": 4.0, "anthropic": 3.5, "google": 4.0}
+	for _, m := range pricing["models"].([]map[string]any) {
+		if _, ok := m["chars_per_token"]; !ok {
+			if v, ok := charsPerToken[m["provider"].(string)]; ok {
+				m["chars_per_token"] = v
+			}
+		}
+	}
+
+	// Rough inference energy per 1K tokens (input + output) by model size
+	// class, from published per-query estimates. Only for PLARIX_SHOW_ENERGY;
+	// treat as order-of-magnitude figures.
+	energyWhPer1K := map[string]float64{
+		"gpt-4o": 0.3, "gpt-4o-mini": 0.05, "gpt-4-turbo": 0.5, "gpt-3.5-turbo": 0.05,
+		"o1": 1.0, "o1-mini": 0.2, "o3": 1.0, "o3-mini": 0.2, "o4-mini": 0.2,
+		"claude-sonnet-4": 0.3, "claude-3-5-sonnet": 0.3, "claude-3-5-sonnet-latest": 0.3,
+		"claude-haiku-4": 0.05, "claude-3-5-haiku": 0.05, "claude-opus-4": 1.0, "claude-3-opus": 1.0,
+		"gemini-1.5-pro": 0.3, "gemini-1.5-flash": 0.05,
+	}
+	for _, m := range pricing["models"].([]map[string]any) {
+		if v, ok := energyWhPer1K[m["name"].(string)]; ok {
+			m["energy_wh_per_1k_tokens"] = v
+		}
+	}
+
+	// Documented maximum output tokens per request, from each provider's
+	// model pages. Plarix flags a larger max_tokens in a diff.
+	maxOutputTokens := map[string]int{
+		"gpt-4o": 16_384, "gpt-4o-mini": 16_384, "gpt-4-turbo": 4_096, "gpt-3.5-turbo": 4_096,
+		"o1": 100_000, "o1-mini": 65_536, "o3": 100_000, "o3-mini": 100_000, "o4-mini": 100_000,
+		"claude-sonnet-4": 64_000, "claude-3-5-sonnet": 8_192, "claude-3-5-sonnet-latest": 8_192,
+		"claude-haiku-4": 64_000, "claude-3-5-haiku": 8_192, "claude-opus-4": 64_000, "claude-3-opus": 4_096,
+		"gemini-1.5-pro": 8_192, "gemini-1.5-flash": 8_192,
+	}
+	for _, m := range pricing["models"].([]map[string]any) {
+		if v, ok := maxOutputTokens[m["name"].(string)]; ok {
+			m["max_output_tokens"] = v
+		}
+	}
+
+	// Minimum USD charge per call, by provider. None of the bundled
+	// providers documents one today (billing is per token with no floor);
+	// add an entry here when a provider introduces one.
+	minChargePerCall := map[string]float64{}
+	for _, m := range pricing["models"].([]map[string]any) {
+		if v, ok := minChargePerCall[m["provider"].(string)]; ok {
+			m["min_charge_per_call"] = v
+		}
+	}
+
 	data, err := json.MarshalIndent(pricing, "", "  ")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
@@ -57,4 +164,92 @@ func main() {
 	}
 
 	fmt.Println("Updated pricing.json and cmd/plarix/pricing.json")
+
+	var next pricingFile
+	if err := json.Unmarshal(data, &next); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	changes := diffPricing(previous, next)
+	fmt.Println()
+	fmt.Print(changes)
+
+	if *changesPath != "" {
+		if err := os.WriteFile(*changesPath, []byte(changes), 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing %s: %v\n", *changesPath, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %s\n", *changesPath)
+	}
+}
+
+// diffPricing renders added, removed and re-priced models as markdown.
+func diffPricing(prev, next pricingFile) string {
+	key := func(m modelPrice) string { return m.Provider + "/" + m.Name }
+	before := make(map[string]modelPrice, len(prev.Models))
+	for _, m := range prev.Models {
+		before[key(m)] = m
+	}
+	after := make(map[string]modelPrice, len(next.Models))
+	for _, m := range next.Models {
+		after[key(m)] = m
+	}
+
+	var added, removed, changed []string
+	for k, m := range after {
+		old, ok := before[k]
+		switch {
+		case !ok:
+			added = append(added, fmt.Sprintf("- `%s`: $%s in / $%s out", k, rate(m.InputPerMillion), rate(m.OutputPerMillion)))
+		case old.InputPerMillion != m.InputPerMillion || old.OutputPerMillion != m.OutputPerMillion:
+			changed = append(changed, fmt.Sprintf("- `%s`: $%s → $%s in / $%s → $%s out", k,
+				rate(old.InputPerMillion), rate(m.InputPerMillion),
+				rate(old.OutputPerMillion), rate(m.OutputPerMillion)))
+		case old.CachedInputPerMillion != m.CachedInputPerMillion:
+			changed = append(changed, fmt.Sprintf("- `%s`: cached input $%s → $%s", k, rate(old.CachedInputPerMillion), rate(m.CachedInputPerMillion)))
+		case old.NamePattern != m.NamePattern:
+			changed = append(changed, fmt.Sprintf("- `%s`: pattern %q → %q", k, old.NamePattern, m.NamePattern))
+		case old.Tier.String() != m.Tier.String():
+			changed = append(changed, fmt.Sprintf("- `%s`: tier %s → %s", k, old.Tier, m.Tier))
+		case old.MinChargePerCall != m.MinChargePerCall:
+			changed = append(changed, fmt.Sprintf("- `%s`: minimum per call $%s → $%s", k, rate(old.MinChargePerCall), rate(m.MinChargePerCall)))
+		}
+	}
+	for k, m := range before {
+		if _, ok := after[k]; !ok {
+			removed = append(removed, fmt.Sprintf("- `%s`: was $%s in / $%s out", k, rate(m.InputPerMillion), rate(m.OutputPerMillion)))
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Pricing changes (%s → %s)\n\n", valueOr(prev.LastUpdated, "none"), next.LastUpdated)
+	if len(added)+len(removed)+len(changed) == 0 {
+		fmt.Fprintf(&b, "No rate changes.\n")
+		return b.String()
+	}
+	writeSection(&b, "Added", added)
+	writeSection(&b, "Removed", removed)
+	writeSection(&b, "Changed", changed)
+	return b.String()
+}
+
+func writeSection(b *strings.Builder, title string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "### %s\n\n%s\n\n", title, strings.Join(lines, "\n"))
+}
+
+func rate(v float64) string {
+	return fmt.Sprintf("%g", v)
+}
+
+func valueOr(val, fallback string) string {
+	if strings.TrimSpace(val) == "" {
+		return fallback
+	}
+	return val
 }